@@ -16,13 +16,456 @@
 
 package riff
 
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/88250/gulu"
+	"github.com/siyuan-note/filelock"
+	"github.com/siyuan-note/logging"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
 // Log 描述了复习日志记录。
 type Log struct {
 	ID            string
+	DeckID        string
 	CardID        string
 	Rating        Rating
 	ScheduledDays uint64
 	ElapsedDays   uint64
 	Reviewed      int64
 	State         State
+	Tombstone     bool // 是否为删除标记，读取时会跳过该 ID 的所有日志
+}
+
+// 复习日志按闪卡包和月份保存在 logs/<deckID>/yyyyMM.log 中，每条日志是一条追加写入的记录（见 record.go）。
+//
+// 早期版本将同一个数据文件夹下所有闪卡包的日志共同保存在 logs/yyyyMM.log 中，更早的版本则将整个月的日志保存为一个
+// msgpack 数组 logs/yyyyMM.msgpack。加载闪卡包时会按闪卡所属的闪卡包将这些共享的日志拆分到各自的文件夹中，
+// 不属于任何闪卡包的日志（比如闪卡已经被移除）保存在 logs/orphans 中。
+
+const (
+	logFileExt       = ".log"
+	legacyLogFileExt = ".msgpack"
+	orphanLogsDir    = "orphans"
+)
+
+// appendLog 将复习日志 log 追加到 logsDir 下所属月份的日志文件中。
+func appendLog(logsDir string, log *Log) (err error) {
+	return appendRecords(filepath.Join(logsDir, getLogMonth(log)+logFileExt), log)
+}
+
+func getDeckLogsDir(saveDir, deckID string) string {
+	return filepath.Join(saveDir, "logs", deckID)
+}
+
+// readMonthLogs 按写入顺序读取 logsDir 下 yyyyMM 月份的复习日志，fn 返回 false 时停止读取，已删除的日志会被跳过。
+func readMonthLogs(logsDir, yyyyMM string, fn func(log *Log) bool) (err error) {
+	if err = migrateLegacyLogs(logsDir, yyyyMM); nil != err {
+		return
+	}
+
+	p := filepath.Join(logsDir, yyyyMM+logFileExt)
+	var logs []*Log
+	removed := map[string]bool{}
+	var unmarshalErr error
+	err = readRecords(p, func(data []byte) bool {
+		log := &Log{}
+		if unmarshalErr = msgpack.Unmarshal(data, log); nil != unmarshalErr {
+			return false
+		}
+		if log.Tombstone {
+			removed[log.ID] = true
+		} else {
+			logs = append(logs, log)
+		}
+		return true
+	})
+	if nil == err {
+		err = unmarshalErr
+	}
+	if nil != err {
+		logging.LogErrorf("read logs [%s] failed: %s", p, err)
+		return
+	}
+
+	for _, log := range logs {
+		if removed[log.ID] {
+			continue
+		}
+		if !fn(log) {
+			return
+		}
+	}
+	return
+}
+
+// removeLog 在 logsDir 下日志 log 所属月份的日志文件中追加一条删除标记。
+func removeLog(logsDir string, log *Log) (err error) {
+	tombstone := &Log{ID: log.ID, DeckID: log.DeckID, CardID: log.CardID, Reviewed: log.Reviewed, Tombstone: true}
+	return appendLog(logsDir, tombstone)
+}
+
+// getLogMonths 返回 logsDir 下所有保存了复习日志的月份，按时间先后排序。
+func getLogMonths(logsDir string) (ret []string, err error) {
+	months := map[string]bool{}
+	for _, ext := range []string{logFileExt, legacyLogFileExt} {
+		var paths []string
+		if paths, err = filepath.Glob(filepath.Join(logsDir, "*"+ext)); nil != err {
+			return
+		}
+		for _, p := range paths {
+			months[strings.TrimSuffix(filepath.Base(p), ext)] = true
+		}
+	}
+
+	for month := range months {
+		ret = append(ret, month)
+	}
+	sort.Strings(ret)
+	return
+}
+
+// migrateLegacyLogs 将 logsDir 下 yyyyMM 月份的旧格式日志文件迁移为追加写入的格式，旧日志排在已有的新格式日志之前。
+func migrateLegacyLogs(logsDir, yyyyMM string) (err error) {
+	legacy := filepath.Join(logsDir, yyyyMM+legacyLogFileExt)
+	if !filelock.IsExist(legacy) {
+		return
+	}
+
+	filelock.Lock(legacy)
+	defer filelock.Unlock(legacy)
+	if !gulu.File.IsExist(legacy) {
+		return // 已经被其他调用方迁移
+	}
+
+	data, err := os.ReadFile(legacy)
+	if nil != err {
+		logging.LogErrorf("read legacy logs [%s] failed: %s", legacy, err)
+		return
+	}
+	var logs []*Log
+	if err = msgpack.Unmarshal(data, &logs); nil != err {
+		logging.LogErrorf("unmarshal legacy logs [%s] failed: %s", legacy, err)
+		return
+	}
+
+	values := make([]interface{}, len(logs))
+	for i, log := range logs {
+		values[i] = log
+	}
+	buf, err := encodeRecords(values...)
+	if nil != err {
+		return
+	}
+
+	p := filepath.Join(logsDir, yyyyMM+logFileExt)
+	filelock.Lock(p)
+	defer filelock.Unlock(p)
+	if gulu.File.IsExist(p) {
+		if data, err = os.ReadFile(p); nil != err {
+			return
+		}
+		buf = append(buf, data...)
+	}
+	if err = writeFileAtomicUnlocked(p, buf, 0); nil != err {
+		logging.LogErrorf("write migrated logs [%s] failed: %s", p, err)
+		return
+	}
+	if err = os.Remove(legacy); nil != err {
+		logging.LogErrorf("remove legacy logs [%s] failed: %s", legacy, err)
+		return
+	}
+	logging.LogInfof("migrated [%d] logs from [%s] to [%s]", len(logs), legacy, p)
+	return
+}
+
+// MigrateLogs 将数据文件夹 saveDir 下所有闪卡包共享的复习日志文件迁移为按闪卡包保存的格式。
+func MigrateLogs(saveDir string) (err error) {
+	return splitSharedLogs(saveDir)
+}
+
+// splitSharedLogs 将数据文件夹 saveDir 下共享的日志文件按闪卡所属的闪卡包拆分到各自的日志文件夹中。
+//
+// 已经拆分过的日志不会重复写入，所以中途失败后可以重新执行。
+func splitSharedLogs(saveDir string) (err error) {
+	logsDir := filepath.Join(saveDir, "logs")
+	months, err := getLogMonths(logsDir)
+	if nil != err || 1 > len(months) {
+		return
+	}
+
+	filelock.Lock(logsDir)
+	defer filelock.Unlock(logsDir)
+	if months, err = getLogMonths(logsDir); nil != err || 1 > len(months) {
+		return // 已经被其他调用方拆分
+	}
+
+	cardDecks, err := getCardDecks(saveDir)
+	if nil != err {
+		return
+	}
+
+	for _, month := range months {
+		partitions := map[string][]*Log{}
+		count := 0
+		if err = readMonthLogs(logsDir, month, func(log *Log) bool {
+			dir := orphanLogsDir
+			if deckID := cardDecks[log.CardID]; "" != deckID {
+				log.DeckID = deckID
+				dir = deckID
+			}
+			partitions[dir] = append(partitions[dir], log)
+			count++
+			return true
+		}); nil != err {
+			return
+		}
+
+		for dir, logs := range partitions {
+			if err = mergeMonthLogs(filepath.Join(logsDir, dir), month, logs); nil != err {
+				logging.LogErrorf("split logs [%s] into [%s] failed: %s", month, dir, err)
+				return
+			}
+		}
+
+		p := filepath.Join(logsDir, month+logFileExt)
+		if err = filelock.Remove(p); nil != err {
+			logging.LogErrorf("remove shared logs [%s] failed: %s", p, err)
+			return
+		}
+		logging.LogInfof("split [%d] shared logs of [%s] into [%d] partitions", count, month, len(partitions))
+	}
+	return
+}
+
+// mergeMonthLogs 将 logs 中尚未保存过的日志追加到 logsDir 下 yyyyMM 月份的日志文件中。
+func mergeMonthLogs(logsDir, yyyyMM string, logs []*Log) (err error) {
+	saved := map[string]bool{}
+	if err = readMonthLogs(logsDir, yyyyMM, func(log *Log) bool {
+		saved[log.ID] = true
+		return true
+	}); nil != err {
+		return
+	}
+
+	var values []interface{}
+	for _, log := range logs {
+		if !saved[log.ID] {
+			values = append(values, log)
+		}
+	}
+	if 1 > len(values) {
+		return
+	}
+	return appendRecords(filepath.Join(logsDir, yyyyMM+logFileExt), values...)
+}
+
+// getCardDecks 返回数据文件夹 saveDir 下每张闪卡所属的闪卡包 ID，包括只存在于预写日志中的闪卡。
+func getCardDecks(saveDir string) (ret map[string]string, err error) {
+	ret = map[string]string{}
+	deckIDs := map[string]bool{}
+	for _, ext := range []string{".cards", journalFileExt} {
+		var paths []string
+		if paths, err = filepath.Glob(filepath.Join(saveDir, "*"+ext)); nil != err {
+			return
+		}
+		for _, p := range paths {
+			deckIDs[strings.TrimSuffix(filepath.Base(p), ext)] = true
+		}
+	}
+
+	var ids []string
+	for id := range deckIDs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, deckID := range ids {
+		cardIDs := map[string]bool{}
+		var generation int
+		if _, generation, err = readFileGeneration(getCardsMsgpackPath(saveDir, deckID), func(data []byte) (err error) {
+			raws := map[string]msgpack.RawMessage{}
+			if err = msgpack.Unmarshal(data, &raws); nil != err {
+				return
+			}
+			for id := range raws {
+				cardIDs[id] = true
+			}
+			return
+		}); nil != err {
+			return
+		}
+		for _, p := range getJournalPaths(saveDir, deckID, generation) {
+			if err = readJournal(p, func(entry *journalEntry) bool {
+				if journalRemove == entry.Op {
+					delete(cardIDs, entry.ID)
+				} else {
+					cardIDs[entry.ID] = true
+				}
+				return true
+			}); nil != err {
+				return
+			}
+		}
+
+		for cardID := range cardIDs {
+			if _, ok := ret[cardID]; !ok {
+				ret[cardID] = deckID
+			}
+		}
+	}
+	return
+}
+
+func getLogMonth(log *Log) string {
+	reviewed := time.Now()
+	if 0 < log.Reviewed {
+		reviewed = time.Unix(log.Reviewed, 0)
+	}
+	return reviewed.Format("200601")
+}
+
+// loadLogs 按复习时间先后顺序加载数据文件夹 saveDir 下所有闪卡包的复习日志。
+func loadLogs(saveDir string) (ret []*Log, err error) {
+	err = NewLogReader(saveDir).Query(nil, func(log *Log) bool {
+		ret = append(ret, log)
+		return true
+	})
+	return
+}
+
+// LogQuery 描述了复习日志的查询条件，零值字段表示不限制该条件。
+type LogQuery struct {
+	DeckIDs []string  // 闪卡包 ID
+	CardIDs []string  // 闪卡 ID
+	Ratings []Rating  // 评分
+	States  []State   // 复习前的闪卡状态
+	Start   time.Time // 复习时间下限（包含）
+	End     time.Time // 复习时间上限（不包含）
+}
+
+func (query *LogQuery) match(log *Log) bool {
+	if 0 < len(query.CardIDs) && !slices.Contains(query.CardIDs, log.CardID) {
+		return false
+	}
+	if 0 < len(query.Ratings) && !slices.Contains(query.Ratings, log.Rating) {
+		return false
+	}
+	if 0 < len(query.States) && !slices.Contains(query.States, log.State) {
+		return false
+	}
+	if !query.Start.IsZero() && log.Reviewed < query.Start.Unix() {
+		return false
+	}
+	if !query.End.IsZero() && log.Reviewed >= query.End.Unix() {
+		return false
+	}
+	return true
+}
+
+// matchMonth 判断 yyyyMM 月份的日志文件是否可能包含满足时间范围的日志。
+func (query *LogQuery) matchMonth(yyyyMM string) bool {
+	// 日志文件按本地时间的月份拆分，查询时间需要先转换为本地时间
+	if !query.Start.IsZero() && yyyyMM < query.Start.In(time.Local).Format("200601") {
+		return false
+	}
+	if !query.End.IsZero() && yyyyMM > query.End.Add(-time.Second).In(time.Local).Format("200601") {
+		return false
+	}
+	return true
+}
+
+// LogReader 描述了复习日志读取器。
+type LogReader struct {
+	logsDir string // 日志文件夹路径
+}
+
+// NewLogReader 新建数据文件夹 saveDir 下复习日志的读取器。
+func NewLogReader(saveDir string) *LogReader {
+	return &LogReader{logsDir: filepath.Join(saveDir, "logs")}
+}
+
+// Query 按复习时间先后顺序读取满足查询条件 query 的日志，fn 返回 false 时停止读取。
+//
+// 只会打开查询的闪卡包在时间范围内的月份文件，每次只在内存中保留一个月份的日志。
+func (reader *LogReader) Query(query *LogQuery, fn func(log *Log) bool) (err error) {
+	if nil == query {
+		query = &LogQuery{}
+	}
+
+	dirs, err := reader.getLogsDirs(query)
+	if nil != err {
+		return
+	}
+	dirMonths := map[string][]string{}
+	var months []string
+	for _, dir := range dirs {
+		var ms []string
+		if ms, err = getLogMonths(dir); nil != err {
+			return
+		}
+		for _, month := range ms {
+			if !query.matchMonth(month) {
+				continue
+			}
+			if _, ok := dirMonths[month]; !ok {
+				months = append(months, month)
+			}
+			dirMonths[month] = append(dirMonths[month], dir)
+		}
+	}
+	sort.Strings(months)
+
+	for _, month := range months {
+		var logs []*Log
+		for _, dir := range dirMonths[month] {
+			if err = readMonthLogs(dir, month, func(log *Log) bool {
+				if query.match(log) {
+					logs = append(logs, log)
+				}
+				return true
+			}); nil != err {
+				return
+			}
+		}
+
+		sort.SliceStable(logs, func(i, j int) bool { return logs[i].Reviewed < logs[j].Reviewed })
+		for _, log := range logs {
+			if !fn(log) {
+				return
+			}
+		}
+	}
+	return
+}
+
+// getLogsDirs 返回查询条件 query 需要读取的日志文件夹。
+func (reader *LogReader) getLogsDirs(query *LogQuery) (ret []string, err error) {
+	if 0 < len(query.DeckIDs) {
+		for _, deckID := range gulu.Str.RemoveDuplicatedElem(query.DeckIDs) {
+			ret = append(ret, filepath.Join(reader.logsDir, deckID))
+		}
+		return
+	}
+
+	// 没有指定闪卡包时还需要读取尚未拆分的共享日志
+	ret = append(ret, reader.logsDir)
+	entries, err := os.ReadDir(reader.logsDir)
+	if nil != err {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			ret = append(ret, filepath.Join(reader.logsDir, entry.Name()))
+		}
+	}
+	return
 }
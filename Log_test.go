@@ -0,0 +1,189 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestSaveLog(t *testing.T) {
+	const saveDir = "testdata"
+	logsDir := filepath.Join(saveDir, "logs")
+	os.MkdirAll(logsDir, 0755)
+	defer os.RemoveAll(saveDir)
+
+	// 旧格式的日志文件
+	reviewed := time.Date(2023, 5, 20, 8, 0, 0, 0, time.Local)
+	legacy := []*Log{
+		{ID: newID(), CardID: "card0", Rating: Good, Reviewed: reviewed.Unix()},
+		{ID: newID(), CardID: "card1", Rating: Again, Reviewed: reviewed.Add(time.Hour).Unix()},
+	}
+	data, err := msgpack.Marshal(legacy)
+	if nil != err {
+		t.Fatal(err)
+	}
+	legacyPath := filepath.Join(logsDir, "202305"+legacyLogFileExt)
+	if err = os.WriteFile(legacyPath, data, 0644); nil != err {
+		t.Fatal(err)
+	}
+
+	// 旧版本中所有闪卡包共享日志，card0 保存在闪卡包快照中，card1 只存在于另一个闪卡包的预写日志中
+	store := NewFSRSStore("test-logs", saveDir, requestRetention, maximumInterval, weights)
+	store.AddCard("card0", newID())
+	if err = store.Save(); nil != err {
+		t.Fatal(err)
+	}
+	other := NewFSRSStore("test-logs-other", saveDir, requestRetention, maximumInterval, weights)
+	other.AddCard("card1", newID())
+	if err = appendRecords(filepath.Join(logsDir, "202305"+logFileExt), &Log{ID: newID(), CardID: "card9", Rating: Good, Reviewed: reviewed.Add(-time.Hour).Unix()}); nil != err {
+		t.Fatal(err)
+	}
+
+	if err = store.Load(); nil != err {
+		t.Fatal(err)
+	}
+	if months, _ := getLogMonths(logsDir); 0 < len(months) {
+		t.Fatalf("shared logs %v not split", months)
+	}
+	appended := &Log{ID: newID(), CardID: "card0", Rating: Easy, Reviewed: reviewed.Add(24 * time.Hour).Unix()}
+	if err = store.SaveLog(appended); nil != err {
+		t.Fatal(err)
+	}
+	// 重复拆分不会写入重复的日志
+	if err = MigrateLogs(saveDir); nil != err {
+		t.Fatal(err)
+	}
+
+	logs, err := loadLogs(saveDir)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 4 != len(logs) {
+		t.Fatalf("logs len [%d] != [4]", len(logs))
+	}
+	expected := []string{"", legacy[0].ID, legacy[1].ID, appended.ID}
+	for i, id := range expected {
+		if "" != id && id != logs[i].ID {
+			t.Fatalf("log [%d] id [%s] != [%s]", i, logs[i].ID, id)
+		}
+	}
+
+	deckIDs := map[string]string{}
+	for _, log := range logs {
+		deckIDs[log.CardID] = log.DeckID
+	}
+	if "test-logs" != deckIDs["card0"] || "test-logs-other" != deckIDs["card1"] || "" != deckIDs["card9"] {
+		t.Fatalf("logs split into wrong decks: %v", deckIDs)
+	}
+	if months, _ := getLogMonths(filepath.Join(logsDir, orphanLogsDir)); 1 != len(months) {
+		t.Fatal("orphan logs not kept")
+	}
+}
+
+func TestLogReader(t *testing.T) {
+	const saveDir = "testdata"
+	os.MkdirAll(saveDir, 0755)
+	defer os.RemoveAll(saveDir)
+
+	store := NewFSRSStore("test-log-reader", saveDir, requestRetention, maximumInterval, weights)
+	may := time.Date(2023, 5, 20, 8, 0, 0, 0, time.Local)
+	june := time.Date(2023, 6, 1, 8, 0, 0, 0, time.Local)
+	logs := []*Log{
+		{ID: newID(), CardID: "card0", Rating: Good, Reviewed: may.Add(time.Hour).Unix()},
+		{ID: newID(), CardID: "card1", Rating: Again, Reviewed: may.Unix()},
+		{ID: newID(), CardID: "card0", Rating: Again, State: Review, Reviewed: june.Unix()},
+		{ID: newID(), CardID: "card1", Rating: Easy, Reviewed: june.Add(time.Hour).Unix()},
+	}
+	for _, log := range logs {
+		if err := store.SaveLog(log); nil != err {
+			t.Fatal(err)
+		}
+	}
+
+	query := func(q *LogQuery) (ret []string) {
+		if err := NewLogReader(saveDir).Query(q, func(log *Log) bool {
+			ret = append(ret, log.ID)
+			return true
+		}); nil != err {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	if ids := query(nil); 4 != len(ids) || logs[1].ID != ids[0] || logs[0].ID != ids[1] {
+		t.Fatalf("logs not streamed in reviewed order: %v", ids)
+	}
+	if ids := query(&LogQuery{CardIDs: []string{"card0"}}); 2 != len(ids) {
+		t.Fatalf("card logs len [%d] != [2]", len(ids))
+	}
+	if ids := query(&LogQuery{Ratings: []Rating{Again}, States: []State{Review}}); 1 != len(ids) || logs[2].ID != ids[0] {
+		t.Fatalf("rating and state query mismatched: %v", ids)
+	}
+	if ids := query(&LogQuery{Start: june, End: june.Add(time.Hour)}); 1 != len(ids) || logs[2].ID != ids[0] {
+		t.Fatalf("time range query mismatched: %v", ids)
+	}
+	// 其他时区的查询时间按本地时间匹配日志文件的月份
+	_, offset := june.Zone()
+	zone := time.FixedZone("test", offset-13*60*60)
+	if ids := query(&LogQuery{Start: june.In(zone), End: june.Add(time.Hour).In(zone)}); 1 != len(ids) || logs[2].ID != ids[0] {
+		t.Fatalf("time range query in another zone mismatched: %v", ids)
+	}
+	monthQuery := &LogQuery{End: time.Date(2023, 6, 1, 0, 0, 0, 0, time.Local)}
+	if !monthQuery.matchMonth("202305") || monthQuery.matchMonth("202306") {
+		t.Fatal("month files out of time range should be skipped")
+	}
+}
+
+func TestSaveLogAfterCrash(t *testing.T) {
+	const saveDir = "testdata"
+	os.MkdirAll(saveDir, 0755)
+	defer os.RemoveAll(saveDir)
+
+	store := NewFSRSStore("test-log-crash", saveDir, requestRetention, maximumInterval, weights)
+	reviewed := time.Date(2023, 5, 20, 8, 0, 0, 0, time.Local)
+	for i := 0; i < 2; i++ {
+		if err := store.SaveLog(&Log{ID: newID(), CardID: "card0", Rating: Good, Reviewed: reviewed.Unix()}); nil != err {
+			t.Fatal(err)
+		}
+	}
+
+	// 模拟写入时崩溃，最后一条日志只写入了一部分
+	p := filepath.Join(getDeckLogsDir(saveDir, store.id), "202305"+logFileExt)
+	info, err := os.Stat(p)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if err = os.Truncate(p, info.Size()-3); nil != err {
+		t.Fatal(err)
+	}
+
+	if err = store.SaveLog(&Log{ID: newID(), CardID: "card0", Rating: Easy, Reviewed: reviewed.Add(time.Hour).Unix()}); nil != err {
+		t.Fatal(err)
+	}
+	logs, err := loadLogs(saveDir)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 2 != len(logs) || Easy != logs[1].Rating {
+		t.Fatalf("logs len [%d] != [2]", len(logs))
+	}
+}
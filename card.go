@@ -16,7 +16,11 @@
 
 package riff
 
-import "time"
+import (
+	"slices"
+	"strings"
+	"time"
+)
 
 // Card 描述了闪卡。
 type Card interface {
@@ -32,7 +36,12 @@ type Card interface {
 	// SetNextDues 设置每种评分对应的下次到期时间。
 	SetNextDues(map[Rating]time.Time)
 
+	// GetDue 返回到期时间。
+	GetDue() time.Time
+
 	// SetDue 设置到期时间。
+	//
+	// 只修改闪卡本身，修改存储中的闪卡需要使用 Store.SetDue 或者 Deck.SetDue，或者修改后调用 SetCard，否则到期索引和预写日志不会更新。
 	SetDue(time.Time)
 
 	// GetLapses 返回闪卡的遗忘次数。
@@ -44,6 +53,9 @@ type Card interface {
 	// GetState 返回闪卡状态。
 	GetState() State
 
+	// GetCardState 返回闪卡当前的复习状态，调用方不需要关心具体的间隔重复算法。
+	GetCardState() CardState
+
 	// GetLastReview 返回闪卡的最后复习时间。
 	GetLastReview() time.Time
 
@@ -55,13 +67,57 @@ type Card interface {
 
 	// SetImpl 设置具体的闪卡实现。
 	SetImpl(c interface{})
+
+	// IsSuspended 返回闪卡是否已暂停，暂停的闪卡不会参与复习，直到取消暂停。
+	IsSuspended() bool
+
+	// SetSuspended 设置闪卡是否暂停。
+	SetSuspended(suspended bool)
+
+	// GetBuriedUntil 返回闪卡搁置到的时间，在此之前闪卡不会参与复习，零值表示没有搁置。
+	GetBuriedUntil() time.Time
+
+	// SetBuriedUntil 设置闪卡搁置到的时间，零值表示取消搁置。
+	SetBuriedUntil(until time.Time)
+
+	// IsLeech 返回闪卡是否为难记闪卡（遗忘次数过多）。
+	IsLeech() bool
+
+	// SetLeech 设置闪卡是否为难记闪卡。
+	SetLeech(leech bool)
+
+	// GetTags 返回闪卡的标签，按字母顺序排序。
+	GetTags() []string
+
+	// SetTags 设置闪卡的标签，会去除空白、空标签和重复的标签。
+	SetTags(tags []string)
+
+	// HasTag 返回闪卡是否带有标签 tag。
+	HasTag(tag string) bool
+}
+
+// CardState 描述了闪卡的复习状态，由各个间隔重复算法的闪卡实现填充。
+type CardState struct {
+	Due           time.Time // 到期时间
+	Stability     float64   // 记忆稳定性，即可提取性降到 90% 所需的天数，算法没有对应概念时为 0
+	Difficulty    float64   // 难度，算法没有对应概念时为 0
+	ElapsedDays   uint64    // 上次复习时距离再上一次复习的天数
+	ScheduledDays uint64    // 上次复习时安排的复习间隔天数
+	Reps          int       // 复习次数
+	Lapses        int       // 遗忘次数
+	State         State     // 状态
+	LastReview    time.Time // 最后复习时间
 }
 
 // BaseCard 描述了基础的闪卡实现。
 type BaseCard struct {
-	CID   string
-	BID   string
-	NDues map[Rating]time.Time
+	CID         string
+	BID         string
+	NDues       map[Rating]time.Time
+	Suspended   bool      // 是否暂停
+	BuriedUntil time.Time // 搁置到的时间
+	Leech       bool      // 是否为难记闪卡
+	Tags        []string  // 标签
 }
 
 func (card *BaseCard) NextDues() map[Rating]time.Time {
@@ -72,6 +128,43 @@ func (card *BaseCard) SetNextDues(dues map[Rating]time.Time) {
 	card.NDues = dues
 }
 
+func (card *BaseCard) IsSuspended() bool {
+	return card.Suspended
+}
+
+func (card *BaseCard) SetSuspended(suspended bool) {
+	card.Suspended = suspended
+}
+
+func (card *BaseCard) GetBuriedUntil() time.Time {
+	return card.BuriedUntil
+}
+
+func (card *BaseCard) SetBuriedUntil(until time.Time) {
+	card.BuriedUntil = until
+}
+
+func (card *BaseCard) IsLeech() bool {
+	return card.Leech
+}
+
+func (card *BaseCard) SetLeech(leech bool) {
+	card.Leech = leech
+}
+
+func (card *BaseCard) GetTags() []string {
+	return slices.Clone(card.Tags)
+}
+
+func (card *BaseCard) SetTags(tags []string) {
+	card.Tags = normalizeTags(tags)
+}
+
+func (card *BaseCard) HasTag(tag string) bool {
+	_, found := slices.BinarySearch(card.Tags, tag)
+	return found
+}
+
 func (card *BaseCard) ID() string {
 	return card.CID
 }
@@ -79,3 +172,14 @@ func (card *BaseCard) ID() string {
 func (card *BaseCard) BlockID() string {
 	return card.BID
 }
+
+// normalizeTags 去除标签两端的空白，丢弃空标签和重复的标签，并按字母顺序排序。
+func normalizeTags(tags []string) (ret []string) {
+	for _, tag := range tags {
+		if tag = strings.TrimSpace(tag); "" != tag {
+			ret = append(ret, tag)
+		}
+	}
+	slices.Sort(ret)
+	return slices.Compact(ret)
+}
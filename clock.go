@@ -0,0 +1,68 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock 描述了时钟，闪卡包和闪卡存储通过它获取当前时间。
+type Clock interface {
+	// Now 返回当前时间。
+	Now() time.Time
+}
+
+// SystemClock 是使用系统时间的时钟，闪卡包默认使用该时钟。
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// ManualClock 描述了手动调整的时钟，用于测试和模拟。
+type ManualClock struct {
+	now  time.Time
+	lock sync.Mutex
+}
+
+// NewManualClock 新建一个停在 now 的时钟。
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+func (clock *ManualClock) Now() time.Time {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	return clock.now
+}
+
+// Set 将时钟调整到 now。
+func (clock *ManualClock) Set(now time.Time) {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	clock.now = now
+}
+
+// Advance 将时钟向后调整 d。
+func (clock *ManualClock) Advance(d time.Duration) {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	clock.now = clock.now.Add(d)
+}
@@ -0,0 +1,71 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClock(t *testing.T) {
+	const saveDir = "testdata"
+	os.MkdirAll(saveDir, 0755)
+	defer os.RemoveAll(saveDir)
+
+	start := time.Date(2023, 5, 20, 8, 0, 0, 0, time.Local)
+	clock := NewManualClock(start)
+	deck, err := LoadDeckWithClock(saveDir, newID(), nil, clock)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if start.UnixMilli() != deck.Created {
+		t.Fatalf("deck created [%d] != [%d]", deck.Created, start.UnixMilli())
+	}
+
+	cardID, blockID := newID(), newID()
+	deck.AddCard(cardID, blockID)
+	log := deck.Review(cardID, Good)
+	if start.Unix() != log.Reviewed || !strings.HasPrefix(log.ID, "20230520080000-") {
+		t.Fatalf("log [id=%s, reviewed=%d] not stamped by clock", log.ID, log.Reviewed)
+	}
+	if 0 != len(deck.Dues()) || 0 != len(deck.GetDueCardsByBlockIDs([]string{blockID})) {
+		t.Fatal("reviewed card should not be due")
+	}
+
+	clock.Advance(30 * 24 * time.Hour)
+	if 1 != len(deck.Dues()) || 1 != len(deck.GetDueCardsByBlockIDs([]string{blockID})) {
+		t.Fatal("card should be due after 30 days")
+	}
+
+	// 导入离线复习的记录
+	offline := start.Add(10 * 24 * time.Hour)
+	log = deck.ReviewAt(cardID, Easy, offline)
+	if offline.Unix() != log.Reviewed || !deck.GetCard(cardID).GetLastReview().Equal(offline) {
+		t.Fatalf("card not reviewed at [%s]", offline)
+	}
+
+	// 早于最后复习时间的离线复习不会被接受
+	reps := deck.GetCard(cardID).GetReps()
+	if log = deck.ReviewAt(cardID, Good, offline.Add(-3*24*time.Hour)); nil != log {
+		t.Fatalf("out-of-order review accepted [elapsed=%d]", log.ElapsedDays)
+	}
+	if card := deck.GetCard(cardID); reps != card.GetReps() || !card.GetLastReview().Equal(offline) {
+		t.Fatal("card changed by out-of-order review")
+	}
+}
@@ -19,10 +19,11 @@ package riff
 import (
 	"errors"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/siyuan-note/filelock"
 	"github.com/siyuan-note/logging"
 	"github.com/vmihailenco/msgpack/v5"
 )
@@ -36,48 +37,133 @@ type Deck struct {
 	Created int64  // 创建时间
 	Updated int64  // 更新时间
 
-	store Store // 底层存储
+	Options *DeckOptions // 选项，修改需要使用 SetOptions，否则重新加载之前不会生效
+
+	store Store         // 底层存储
+	clock Clock         // 时钟
+	undos []*reviewUndo // 撤销复习栈
 	lock  *sync.Mutex
 }
 
-// LoadDeck 从文件夹 saveDir 路径上加载 id 闪卡包。
+const maxReviewUndos = 100 // 最多可以连续撤销的复习次数
+
+// reviewUndo 描述了一次可以撤销的复习。
+type reviewUndo struct {
+	cardID      string      // 闪卡 ID
+	impl        interface{} // 复习前的闪卡实现，即调度状态
+	leech       bool        // 复习是否将闪卡标记为难记闪卡
+	leechTagged bool        // 复习是否为闪卡添加了难记闪卡标签
+	suspended   bool        // 复习是否自动暂停了闪卡
+	after       CardState   // 复习后的调度状态，撤销时闪卡的调度状态必须和它一致
+	log         *Log        // 复习日志
+}
+
+// ErrCardChangedSinceReview 描述了撤销复习时闪卡在复习之后已被修改（比如重新设置了到期时间）的错误。
+var ErrCardChangedSinceReview = errors.New("card changed since review")
+
+// LoadDeck 从文件夹 saveDir 路径上加载 id 闪卡包，新建的闪卡包使用 FSRS 算法。
+//
+// 传入的参数会覆盖闪卡包保存的选项中对应的参数，其他选项保持不变。FSRS 算法参数无效时和 NewFSRSScheduler 一样记录日志并使用默认参数。
+//
+// Deprecated: 使用 LoadDeckWithOptions。
 func LoadDeck(saveDir, id string, requestRetention float64, maximumInterval int, weights string) (deck *Deck, err error) {
-	created := time.Now().UnixMilli()
+	opts, err := loadDeckOptions(saveDir, id)
+	if nil != err {
+		logging.LogErrorf("load deck [%s] failed: %s", id, err)
+		return
+	}
+	opts.FSRS = *parseFSRSParams(requestRetention, maximumInterval, weights)
+	opts.SM2.MaximumInterval = opts.FSRS.MaximumInterval
+	return LoadDeckWithOptions(saveDir, id, opts)
+}
+
+// loadDeckOptions 返回 id 闪卡包保存的选项，没有保存过选项的闪卡包返回默认选项。
+func loadDeckOptions(saveDir, id string) (ret *DeckOptions, err error) {
+	saved := &Deck{}
+	if _, err = readFileWithBackups(getDeckMsgpackPath(saveDir, id), func(data []byte) error {
+		return msgpack.Unmarshal(data, saved)
+	}); nil != err {
+		return
+	}
+
+	if ret = saved.Options; nil == ret {
+		ret = DefaultDeckOptions()
+	}
+	if "" != saved.Algo {
+		ret.Algo = saved.Algo
+	}
+	return
+}
+
+// LoadDeckWithOptions 从文件夹 saveDir 路径上加载 id 闪卡包。
+//
+// opts 不为 nil 时校验后使用并保存到闪卡包中，为 nil 时使用闪卡包已保存的选项，没有保存过选项的闪卡包使用默认选项。
+func LoadDeckWithOptions(saveDir, id string, opts *DeckOptions) (deck *Deck, err error) {
+	return LoadDeckWithClock(saveDir, id, opts, SystemClock)
+}
+
+// LoadDeckWithClock 从文件夹 saveDir 路径上加载 id 闪卡包，闪卡包和底层存储使用 clock 获取当前时间。
+//
+// opts 的用法同 LoadDeckWithOptions，clock 为 nil 时使用系统时钟。
+func LoadDeckWithClock(saveDir, id string, opts *DeckOptions, clock Clock) (deck *Deck, err error) {
+	if nil == clock {
+		clock = SystemClock
+	}
+	if nil != opts {
+		if err = opts.Validate(); nil != err {
+			logging.LogErrorf("load deck [%s] failed: %s", id, err)
+			return
+		}
+		opts = opts.clone()
+	}
+
+	algo := AlgoFSRS
+	if nil != opts {
+		algo = opts.Algo
+	}
+	created := clock.Now().UnixMilli()
 	deck = &Deck{
 		ID:      id,
 		Name:    id,
-		Algo:    AlgoFSRS,
+		Algo:    algo,
 		Created: created,
 		Updated: created,
+		clock:   clock,
 		lock:    &sync.Mutex{},
 	}
 
 	dataPath := getDeckMsgpackPath(saveDir, id)
-	if filelock.IsExist(dataPath) {
-		var data []byte
-		data, err = filelock.ReadFile(dataPath)
-		if nil != err {
-			logging.LogErrorf("load deck [%s] failed: %s", deck.Name, err)
-			return
-		}
-
-		err = msgpack.Unmarshal(data, deck)
-		if nil != err {
-			logging.LogErrorf("load deck [%s] failed: %s", deck.Name, err)
-			return
+	if _, err = readFileWithBackups(dataPath, func(data []byte) (err error) {
+		loaded := *deck
+		if err = msgpack.Unmarshal(data, &loaded); nil == err {
+			*deck = loaded
 		}
+		return
+	}); nil != err {
+		logging.LogErrorf("load deck [%s] failed: %s", deck.Name, err)
+		return
 	}
 
-	var store Store
-	switch deck.Algo {
-	case AlgoFSRS:
-		store = NewFSRSStore(deck.ID, saveDir, requestRetention, maximumInterval, weights)
-		err = store.Load()
-	default:
-		err = errors.New("not supported yet")
+	if nil != opts {
+		deck.Options = opts
+	} else if nil == deck.Options {
+		deck.Options = DefaultDeckOptions()
+	} else if err = deck.Options.Validate(); nil != err {
+		logging.LogErrorf("load deck [%s] failed: %s", deck.Name, err)
 		return
 	}
+	deck.Options.Algo = deck.Algo
+	if 0 == deck.Options.ShuffleSeed {
+		deck.Options.ShuffleSeed = clock.Now().UnixNano()
+	}
+
+	store, err := NewStore(deck.Algo, deck.ID, saveDir, deck.Options)
 	if nil != err {
+		logging.LogErrorf("load deck [%s] failed: %s", deck.Name, err)
+		return
+	}
+	store.SetClock(clock)
+	if err = store.Load(); nil != err {
 		return
 	}
 	deck.store = store
@@ -95,7 +181,7 @@ func (deck *Deck) AddCard(cardID, blockID string) {
 	}
 
 	deck.store.AddCard(cardID, blockID)
-	deck.Updated = time.Now().UnixMilli()
+	deck.Updated = deck.clock.Now().UnixMilli()
 }
 
 // RemoveCard 删除一张闪卡。
@@ -104,15 +190,29 @@ func (deck *Deck) RemoveCard(cardID string) {
 	defer deck.lock.Unlock()
 
 	deck.store.RemoveCard(cardID)
-	deck.Updated = time.Now().UnixMilli()
+	deck.dropUndos(cardID)
+	deck.Updated = deck.clock.Now().UnixMilli()
 }
 
-// SetCard 设置一张闪卡。
+// SetCard 设置一张闪卡，闪卡之前的复习不能再撤销。
 func (deck *Deck) SetCard(card Card) {
 	deck.lock.Lock()
 	defer deck.lock.Unlock()
 
 	deck.store.SetCard(card)
+	deck.dropUndos(card.ID())
+}
+
+// SetDue 设置 cardID 闪卡的到期时间，闪卡之前的复习不能再撤销。
+func (deck *Deck) SetDue(cardID string, due time.Time) {
+	deck.lock.Lock()
+	defer deck.lock.Unlock()
+
+	if nil == deck.store.SetDue(cardID, due) {
+		return
+	}
+	deck.dropUndos(cardID)
+	deck.Updated = deck.clock.Now().UnixMilli()
 }
 
 // GetCard 根据闪卡 ID 获取对应的闪卡。
@@ -144,11 +244,25 @@ func (deck *Deck) GetNewCardsByBlockIDs(blockIDs []string) (ret []Card) {
 	return deck.store.GetNewCardsByBlockIDs(blockIDs)
 }
 
+// GetNewCardsByBlockIDsWithOptions 使用查询选项 opts 获取指定内容块的所有新的闪卡，比如包含暂停或者搁置的闪卡。
+func (deck *Deck) GetNewCardsByBlockIDsWithOptions(blockIDs []string, opts *QueryOptions) (ret []Card) {
+	deck.lock.Lock()
+	defer deck.lock.Unlock()
+	return deck.store.GetNewCardsByBlockIDsWithOptions(blockIDs, opts)
+}
+
 func (deck *Deck) GetDueCardsByBlockIDs(blockIDs []string) (ret []Card) {
 	deck.lock.Lock()
 	defer deck.lock.Unlock()
 
-	return deck.store.GetDueCardsByBlockIDs(blockIDs)
+	return deck.store.GetDueCardsByBlockIDsWithOptions(blockIDs, deck.queryOptions(nil))
+}
+
+// GetDueCardsByBlockIDsWithOptions 使用查询选项 opts 获取指定内容块的所有到期的闪卡，比如包含暂停或者搁置的闪卡。
+func (deck *Deck) GetDueCardsByBlockIDsWithOptions(blockIDs []string, opts *QueryOptions) (ret []Card) {
+	deck.lock.Lock()
+	defer deck.lock.Unlock()
+	return deck.store.GetDueCardsByBlockIDsWithOptions(blockIDs, deck.queryOptions(opts))
 }
 
 // GetBlockIDs 获取所有内容块 ID。
@@ -159,6 +273,19 @@ func (deck *Deck) GetBlockIDs() (ret []string) {
 	return deck.store.GetBlockIDs()
 }
 
+// Snapshot 返回闪卡包中所有闪卡的克隆，修改克隆不会影响闪卡包。
+func (deck *Deck) Snapshot() (ret []Card) {
+	deck.lock.Lock()
+	defer deck.lock.Unlock()
+
+	for _, card := range deck.cards() {
+		if clone := card.Clone(); nil != clone {
+			ret = append(ret, clone)
+		}
+	}
+	return
+}
+
 // CountCards 获取卡包中的闪卡数量。
 func (deck *Deck) CountCards() int {
 	deck.lock.Lock()
@@ -172,7 +299,7 @@ func (deck *Deck) Save() (err error) {
 	deck.lock.Lock()
 	defer deck.lock.Unlock()
 
-	deck.Updated = time.Now().UnixMilli()
+	deck.Updated = deck.clock.Now().UnixMilli()
 	err = deck.store.Save()
 	if nil != err {
 		logging.LogErrorf("save deck [%s] failed: %s", deck.Name, err)
@@ -186,7 +313,7 @@ func (deck *Deck) Save() (err error) {
 		logging.LogErrorf("save deck failed: %s", err)
 		return
 	}
-	if err = filelock.WriteFile(dataPath, data); nil != err {
+	if err = writeFileAtomic(dataPath, data, deck.Options.backups()); nil != err {
 		logging.LogErrorf("save deck failed: %s", err)
 		return
 	}
@@ -201,21 +328,260 @@ func (deck *Deck) SaveLog(log *Log) (err error) {
 	return deck.store.SaveLog(log)
 }
 
-// Review 复习一张闪卡，rating 为复习评分结果。
+// Logs 按复习时间先后顺序读取闪卡包中满足查询条件 query 的复习日志，fn 返回 false 时停止读取。
+//
+// query 中指定的闪卡包 ID 会被忽略。
+func (deck *Deck) Logs(query *LogQuery, fn func(log *Log) bool) (err error) {
+	deck.lock.Lock()
+	q := &LogQuery{}
+	if nil != query {
+		*q = *query
+	}
+	q.DeckIDs = []string{deck.ID}
+	saveDir := deck.store.GetSaveDir()
+	deck.lock.Unlock()
+
+	return NewLogReader(saveDir).Query(q, fn)
+}
+
+// Review 复习一张闪卡。
 func (deck *Deck) Review(cardID string, rating Rating) (ret *Log) {
 	deck.lock.Lock()
 	defer deck.lock.Unlock()
+	return deck.review(cardID, rating, deck.clock.Now())
+}
+
+// ReviewAt 在 at 时复习一张闪卡，比如导入离线复习的记录，at 早于闪卡的最后复习时间时不复习并返回 nil。
+func (deck *Deck) ReviewAt(cardID string, rating Rating, at time.Time) (ret *Log) {
+	deck.lock.Lock()
+	defer deck.lock.Unlock()
+	return deck.review(cardID, rating, at)
+}
+
+func (deck *Deck) review(cardID string, rating Rating, at time.Time) (ret *Log) {
+	card := deck.store.GetCard(cardID)
+	if nil == card {
+		return deck.store.ReviewAt(cardID, rating, at)
+	}
+
+	before := card.Clone()
+	if ret = deck.store.ReviewAt(cardID, rating, at); nil == ret {
+		return
+	}
+	if nil != before {
+		undo := &reviewUndo{cardID: cardID, impl: before.Impl(), after: card.GetCardState(), log: ret}
+		if !before.IsLeech() && card.IsLeech() {
+			undo.leech = true
+			undo.leechTagged = !before.HasTag(LeechTag) && card.HasTag(LeechTag)
+			undo.suspended = !before.IsSuspended() && card.IsSuspended()
+		}
+		deck.undos = append(deck.undos, undo)
+		if maxReviewUndos < len(deck.undos) {
+			deck.undos = deck.undos[len(deck.undos)-maxReviewUndos:]
+		}
+	}
+	deck.Updated = deck.clock.Now().UnixMilli()
+	return
+}
+
+// UndoReview 撤销最近一次复习，将闪卡的调度状态恢复到复习前并删除对应的复习日志，返回被撤销的复习日志。
+//
+// 复习之后对闪卡的其他修改（比如标签、暂停和搁置）会保留，复习标记的难记闪卡、添加的难记闪卡标签和自动暂停会被撤销。
+//
+// 最多可以连续撤销 100 次复习，没有可以撤销的复习时返回 nil。闪卡在复习之后被移除或者调度状态被修改时，
+// 不会撤销复习，丢弃这次复习的撤销记录并返回 ErrCardChangedSinceReview。
+func (deck *Deck) UndoReview() (ret *Log, err error) {
+	deck.lock.Lock()
+	defer deck.lock.Unlock()
 
-	ret = deck.store.Review(cardID, rating)
-	deck.Updated = time.Now().UnixMilli()
+	if 1 > len(deck.undos) {
+		return
+	}
+	undo := deck.undos[len(deck.undos)-1]
+	deck.undos = deck.undos[:len(deck.undos)-1]
+
+	card := deck.store.GetCard(undo.cardID)
+	if nil == card || !sameCardState(card.GetCardState(), undo.after) {
+		deck.dropUndos(undo.cardID)
+		err = ErrCardChangedSinceReview
+		return
+	}
+
+	card.SetImpl(undo.impl)
+	if undo.leech {
+		card.SetLeech(false)
+	}
+	if undo.leechTagged {
+		card.SetTags(slices.DeleteFunc(card.GetTags(), func(tag string) bool { return LeechTag == tag }))
+	}
+	if undo.suspended {
+		card.SetSuspended(false)
+	}
+	deck.store.SetCard(card)
+	if err = deck.store.RemoveLog(undo.log); nil != err {
+		return
+	}
+	ret = undo.log
+	deck.Updated = deck.clock.Now().UnixMilli()
 	return
 }
 
-// Dues 返回所有到期的闪卡。
+// dropUndos 丢弃 cardID 闪卡的所有撤销记录，比如闪卡被移除或者调度状态被修改之后，调用方需要持有 deck.lock。
+func (deck *Deck) dropUndos(cardID string) {
+	deck.undos = slices.DeleteFunc(deck.undos, func(undo *reviewUndo) bool { return cardID == undo.cardID })
+}
+
+// sameCardState 判断调度状态 a 和 b 是否一致。
+func sameCardState(a, b CardState) bool {
+	if !a.Due.Equal(b.Due) || !a.LastReview.Equal(b.LastReview) {
+		return false
+	}
+	a.Due, a.LastReview, b.Due, b.LastReview = time.Time{}, time.Time{}, time.Time{}, time.Time{}
+	return a == b
+}
+
+// GetOptions 返回闪卡包选项的副本，修改副本不会影响闪卡包。
+func (deck *Deck) GetOptions() *DeckOptions {
+	deck.lock.Lock()
+	defer deck.lock.Unlock()
+	return deck.Options.clone()
+}
+
+// SetOptions 校验并应用闪卡包选项 opts，比如难记闪卡处理选项和算法参数，保存闪卡包时一起保存。
+//
+// 闪卡包的算法不能修改，opts.Algo 会被忽略；opts.ShuffleSeed 为 0 时保留当前的随机数种子。
+func (deck *Deck) SetOptions(opts *DeckOptions) (err error) {
+	deck.lock.Lock()
+	defer deck.lock.Unlock()
+
+	opts = opts.clone()
+	opts.Algo = deck.Algo
+	if 0 == opts.ShuffleSeed {
+		opts.ShuffleSeed = deck.Options.ShuffleSeed
+	}
+	if err = deck.store.SetOptions(opts); nil != err {
+		logging.LogErrorf("set deck [%s] options failed: %s", deck.Name, err)
+		return
+	}
+	deck.Options = opts
+	deck.Updated = deck.clock.Now().UnixMilli()
+	return
+}
+
+// SetClock 设置闪卡包和底层存储获取当前时间使用的时钟，clock 为 nil 时使用系统时钟。
+func (deck *Deck) SetClock(clock Clock) {
+	deck.lock.Lock()
+	defer deck.lock.Unlock()
+
+	if nil == clock {
+		clock = SystemClock
+	}
+	deck.clock = clock
+	deck.store.SetClock(clock)
+}
+
+// Dues 返回所有到期的闪卡，按闪卡包的排序策略排序。
 func (deck *Deck) Dues() (ret []Card) {
 	deck.lock.Lock()
 	defer deck.lock.Unlock()
-	return deck.store.Dues()
+	return deck.store.DuesWithOptions(deck.queryOptions(nil))
+}
+
+// DuesWithOptions 使用查询选项 opts 返回所有到期的闪卡，比如包含暂停或者搁置的闪卡、指定排序策略。
+func (deck *Deck) DuesWithOptions(opts *QueryOptions) (ret []Card) {
+	deck.lock.Lock()
+	defer deck.lock.Unlock()
+	return deck.store.DuesWithOptions(deck.queryOptions(opts))
+}
+
+// Suspend 暂停 cardID 闪卡，暂停的闪卡保留复习记录，但不会参与复习，直到取消暂停。
+func (deck *Deck) Suspend(cardID string) {
+	deck.updateCard(cardID, func(card Card) { card.SetSuspended(true) })
+}
+
+// Unsuspend 取消暂停 cardID 闪卡。
+func (deck *Deck) Unsuspend(cardID string) {
+	deck.updateCard(cardID, func(card Card) { card.SetSuspended(false) })
+}
+
+// Bury 将 cardID 闪卡搁置到 until，在此之前闪卡不会参与复习，until 为零值时取消搁置。
+func (deck *Deck) Bury(cardID string, until time.Time) {
+	deck.updateCard(cardID, func(card Card) { card.SetBuriedUntil(until) })
+}
+
+// AddTags 为 cardID 闪卡添加标签 tags。
+func (deck *Deck) AddTags(cardID string, tags ...string) {
+	deck.updateCard(cardID, func(card Card) { card.SetTags(append(card.GetTags(), tags...)) })
+}
+
+// RemoveTags 移除 cardID 闪卡的标签 tags，移除难记闪卡标签时同时取消难记闪卡标记。
+func (deck *Deck) RemoveTags(cardID string, tags ...string) {
+	removed := normalizeTags(tags)
+	deck.updateCard(cardID, func(card Card) {
+		card.SetTags(slices.DeleteFunc(card.GetTags(), func(tag string) bool { return slices.Contains(removed, tag) }))
+		if slices.Contains(removed, LeechTag) {
+			card.SetLeech(false)
+		}
+	})
+}
+
+// CardsByTag 返回带有标签 tag 的所有闪卡。
+func (deck *Deck) CardsByTag(tag string) (ret []Card) {
+	deck.lock.Lock()
+	defer deck.lock.Unlock()
+	return deck.store.GetCardsByTag(strings.TrimSpace(tag))
+}
+
+// queryOptions 返回查询选项 opts 的副本，没有指定排序策略和随机数种子时使用闪卡包当前的选项，调用方需要持有 deck.lock。
+func (deck *Deck) queryOptions(opts *QueryOptions) *QueryOptions {
+	ret := &QueryOptions{}
+	if nil != opts {
+		*ret = *opts
+	}
+	if "" == ret.Order {
+		ret.Order = deck.Options.Order
+	}
+	if 0 == ret.Seed {
+		ret.Seed = deck.Options.ShuffleSeed
+	}
+	return ret
+}
+
+// Now 返回闪卡包时钟的当前时间。
+func (deck *Deck) Now() time.Time {
+	return deck.getClock().Now()
+}
+
+func (deck *Deck) getClock() Clock {
+	deck.lock.Lock()
+	defer deck.lock.Unlock()
+	return deck.clock
+}
+
+// cards 返回闪卡包中的所有闪卡，调用方需要持有 deck.lock。
+func (deck *Deck) cards() []Card {
+	return deck.store.GetCardsByBlockIDs(deck.store.GetBlockIDs())
+}
+
+// updateCard 使用 update 修改 cardID 闪卡并保存，闪卡不存在时不做任何处理。
+func (deck *Deck) updateCard(cardID string, update func(card Card)) {
+	deck.lock.Lock()
+	defer deck.lock.Unlock()
+
+	card := deck.store.GetCard(cardID)
+	if nil == card {
+		return
+	}
+	update(card)
+	deck.store.SetCard(card)
+	deck.Updated = deck.clock.Now().UnixMilli()
+}
+
+// Retrievability 返回 cardID 闪卡在 at 时的可提取性（回忆概率），闪卡不存在或者是新卡时返回 0。
+func (deck *Deck) Retrievability(cardID string, at time.Time) float64 {
+	deck.lock.Lock()
+	defer deck.lock.Unlock()
+	return deck.store.Retrievability(cardID, at)
 }
 
 func getDeckMsgpackPath(saveDir, id string) string {
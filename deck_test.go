@@ -19,8 +19,6 @@ package riff
 import (
 	"os"
 	"testing"
-
-	"github.com/open-spaced-repetition/go-fsrs/v3"
 )
 
 func TestDeck(t *testing.T) {
@@ -45,9 +43,9 @@ func TestDeck(t *testing.T) {
 	}
 
 	deck.Review(cardID, Good)
-	due := card.Impl().(*fsrs.Card).Due.UnixMilli()
+	due := card.GetCardState().Due.UnixMilli()
 	card = deck.GetCard(cardID)
-	due2 := card.Impl().(*fsrs.Card).Due.UnixMilli()
+	due2 := card.GetCardState().Due.UnixMilli()
 	if due2 != due {
 		t.Fatalf("card due [%v] != [%v]", due2, due)
 	}
@@ -71,7 +69,7 @@ func TestDeck(t *testing.T) {
 	if card.ID() != cardID {
 		t.Fatalf("card id [%s] != [%s]", card.ID(), cardID)
 	}
-	due3 := card.Impl().(*fsrs.Card).Due.UnixMilli()
+	due3 := card.GetCardState().Due.UnixMilli()
 	if due2 != due3 {
 		t.Fatalf("card due [%v] != [%v]", due2, due3)
 	}
@@ -0,0 +1,173 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/88250/gulu"
+	"github.com/siyuan-note/filelock"
+	"github.com/siyuan-note/logging"
+)
+
+// writeFileAtomic 先将 data 写入临时文件并同步到磁盘，然后轮转保留 backups 份旧文件，最后将临时文件重命名为 p。
+//
+// 写入过程中崩溃或者磁盘写满时 p 保持原样，最多留下一个临时文件 p.tmp，下次保存时会被覆盖。
+func writeFileAtomic(p string, data []byte, backups int) (err error) {
+	filelock.Lock(p)
+	defer filelock.Unlock(p)
+	return writeFileAtomicUnlocked(p, data, backups)
+}
+
+// writeFileAtomicUnlocked 同 writeFileAtomic，调用方需要持有 p 的文件锁。
+func writeFileAtomicUnlocked(p string, data []byte, backups int) (err error) {
+	dir := filepath.Dir(p)
+	if !gulu.File.IsDir(dir) {
+		if err = os.MkdirAll(dir, 0755); nil != err {
+			return
+		}
+	}
+
+	// 调用方持有 p 的文件锁，所以可以使用固定的临时文件名
+	tmp := p + ".tmp"
+	if err = writeFileSync(tmp, data); nil != err {
+		os.Remove(tmp)
+		return
+	}
+
+	if 0 < backups && gulu.File.IsExist(p) {
+		if err = rotateBackups(p, backups); nil != err {
+			os.Remove(tmp)
+			return
+		}
+	}
+
+	if err = os.Rename(tmp, p); nil != err {
+		os.Remove(tmp)
+		return
+	}
+	syncDir(dir)
+	return
+}
+
+// readFileWithBackups 读取 p 并使用 unmarshal 解析，p 存在但读取或者解析失败时依次尝试从新到旧的备份。
+//
+// p 不存在时 exist 为 false，不会使用备份（比如闪卡包已被删除），所有文件都无法解析时返回 p 的错误。
+func readFileWithBackups(p string, unmarshal func(data []byte) error) (exist bool, err error) {
+	exist, _, err = readFileGeneration(p, unmarshal)
+	return
+}
+
+// readFileGeneration 同 readFileWithBackups，同时返回成功读取的文件的备份代数 generation，读取的是 p 本身时为 0。
+func readFileGeneration(p string, unmarshal func(data []byte) error) (exist bool, generation int, err error) {
+	if !filelock.IsExist(p) {
+		return
+	}
+
+	paths := []string{p}
+	for i := 1; ; i++ {
+		backup := getBackupPath(p, i)
+		if !filelock.IsExist(backup) {
+			break
+		}
+		paths = append(paths, backup)
+	}
+
+	exist = true
+	var firstErr error
+	for i, path := range paths {
+		var data []byte
+		data, err = filelock.ReadFile(path)
+		if nil == err {
+			err = unmarshal(data)
+		}
+		if nil == err {
+			if path != p {
+				logging.LogWarnf("load [%s] failed: %s, fall back to backup [%s]", p, firstErr, path)
+			}
+			generation = i
+			return
+		}
+
+		logging.LogErrorf("load [%s] failed: %s", path, err)
+		if nil == firstErr {
+			firstErr = err
+		}
+	}
+	err = firstErr
+	return
+}
+
+// rotateBackups 将 p 的备份依次后移一代，丢弃最旧的一代，并将 p 保存为最新的备份。
+func rotateBackups(p string, backups int) (err error) {
+	if err = os.Remove(getBackupPath(p, backups)); nil != err && !errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	for i := backups - 1; 0 < i; i-- {
+		from := getBackupPath(p, i)
+		if !gulu.File.IsExist(from) {
+			continue
+		}
+		if err = os.Rename(from, getBackupPath(p, i+1)); nil != err {
+			return
+		}
+	}
+
+	// 使用硬链接保留旧文件，这样 p 在任何时候都是存在的，不支持硬链接的文件系统上退化为复制
+	latest := getBackupPath(p, 1)
+	if err = os.Link(p, latest); nil == err {
+		return
+	}
+	data, err := os.ReadFile(p)
+	if nil != err {
+		return
+	}
+	return writeFileSync(latest, data)
+}
+
+func getBackupPath(p string, generation int) string {
+	return p + ".bak" + strconv.Itoa(generation)
+}
+
+func writeFileSync(p string, data []byte) (err error) {
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if nil != err {
+		return
+	}
+	if _, err = f.Write(data); nil != err {
+		f.Close()
+		return
+	}
+	if err = f.Sync(); nil != err {
+		f.Close()
+		return
+	}
+	return f.Close()
+}
+
+// syncDir 同步文件夹，确保重命名落盘，部分系统（比如 Windows）不支持时忽略。
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if nil != err {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
@@ -0,0 +1,127 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	const saveDir = "testdata"
+	os.MkdirAll(saveDir, 0755)
+	defer os.RemoveAll(saveDir)
+
+	p := filepath.Join(saveDir, "test.cards")
+	for i := 0; i < 5; i++ {
+		if err := writeFileAtomic(p, []byte(strconv.Itoa(i)), 2); nil != err {
+			t.Fatal(err)
+		}
+	}
+
+	expected := map[string]string{p: "4", getBackupPath(p, 1): "3", getBackupPath(p, 2): "2"}
+	for path, content := range expected {
+		data, err := os.ReadFile(path)
+		if nil != err {
+			t.Fatal(err)
+		}
+		if content != string(data) {
+			t.Fatalf("file [%s] content [%s] != [%s]", path, data, content)
+		}
+	}
+	if _, err := os.Stat(getBackupPath(p, 3)); !os.IsNotExist(err) {
+		t.Fatalf("backup [%s] should be rotated out", getBackupPath(p, 3))
+	}
+	if matches, _ := filepath.Glob(filepath.Join(saveDir, "*.tmp")); 0 < len(matches) {
+		t.Fatalf("temp files left [%v]", matches)
+	}
+
+	// 上次保存中断留下的临时文件会被覆盖，不会累积
+	os.WriteFile(p+".tmp", []byte("interrupted"), 0644)
+	if err := writeFileAtomic(p, []byte("5"), 2); nil != err {
+		t.Fatal(err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(saveDir, "*.tmp")); 0 < len(matches) {
+		t.Fatalf("temp files left [%v]", matches)
+	}
+	if data, _ := os.ReadFile(getBackupPath(p, 1)); "4" != string(data) {
+		t.Fatalf("backup content [%s] != [4]", data)
+	}
+
+	// 主文件损坏时回退到最新的可用备份
+	os.WriteFile(p, []byte("broken"), 0644)
+	var loaded int
+	exist, err := readFileWithBackups(p, func(data []byte) (err error) {
+		loaded, err = strconv.Atoi(string(data))
+		return
+	})
+	if !exist || nil != err || 4 != loaded {
+		t.Fatalf("read with backups [exist=%v, err=%v, loaded=%d]", exist, err, loaded)
+	}
+
+	// 主文件不存在时不回退到备份
+	os.Remove(p)
+	if exist, err = readFileWithBackups(p, func(data []byte) error { return nil }); exist || nil != err {
+		t.Fatalf("read removed file [exist=%v, err=%v]", exist, err)
+	}
+}
+
+func TestDeckFallbackToBackup(t *testing.T) {
+	const saveDir = "testdata"
+	os.MkdirAll(saveDir, 0755)
+	defer os.RemoveAll(saveDir)
+
+	deckID := newID()
+	deck, err := LoadDeckWithOptions(saveDir, deckID, nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	cardID := newID()
+	deck.AddCard(cardID, newID())
+	if err = deck.Save(); nil != err {
+		t.Fatal(err)
+	}
+	deck.Name = "deck0"
+	if err = deck.Save(); nil != err {
+		t.Fatal(err)
+	}
+
+	os.WriteFile(getDeckMsgpackPath(saveDir, deckID), []byte("broken"), 0644)
+	os.WriteFile(filepath.Join(saveDir, deckID+".cards"), []byte("broken"), 0644)
+	deck, err = LoadDeckWithOptions(saveDir, deckID, nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if nil == deck.GetCard(cardID) {
+		t.Fatalf("card [%s] not loaded from backup", cardID)
+	}
+	if deckID != deck.Name {
+		t.Fatalf("deck name [%s] != [%s]", deck.Name, deckID)
+	}
+
+	// 删除闪卡包文件后不会从备份中恢复
+	os.Remove(getDeckMsgpackPath(saveDir, deckID))
+	os.Remove(filepath.Join(saveDir, deckID+".cards"))
+	if deck, err = LoadDeckWithOptions(saveDir, deckID, nil); nil != err {
+		t.Fatal(err)
+	}
+	if 0 != deck.CountCards() {
+		t.Fatalf("removed deck cards count [%d] != [0]", deck.CountCards())
+	}
+}
@@ -0,0 +1,247 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/siyuan-note/logging"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// FilterQuery 描述了筛选闪卡包的查询条件，条件之间是“且”的关系。
+type FilterQuery struct {
+	QueryOptions // 暂停、搁置、标签和排序策略等查询选项
+
+	BlockIDs          []string // 只包含这些内容块的闪卡，为空时不限制
+	States            []State  // 只包含这些状态的闪卡，为空时不限制
+	MinLapses         int      // 只包含遗忘次数不少于该值的闪卡，为 0 时不限制
+	MinRetrievability float64  // 只包含可提取性不低于该值的闪卡，为 0 时不限制
+	MaxRetrievability float64  // 只包含可提取性不高于该值的已复习过的闪卡，为 0 时不限制
+	DueOnly           bool     // 是否只包含到期的闪卡
+	Limit             int      // 最多包含的闪卡数，为 0 时不限制
+}
+
+// FilteredDeck 描述了筛选闪卡包（自定义学习），其中的闪卡由保存的查询条件从若干来源闪卡包中筛选得到。
+//
+// 筛选闪卡包本身不保存闪卡，复习会更新来源闪卡包中的闪卡，复习日志也写入来源闪卡包。
+type FilteredDeck struct {
+	ID          string       // ID
+	Name        string       // 名称
+	DeckIDs     []string     // 来源闪卡包 ID
+	Query       *FilterQuery // 查询条件
+	ShuffleSeed int64        // 打乱顺序使用的随机数种子，查询条件没有指定种子时使用
+	Created     int64        // 创建时间
+	Updated     int64        // 更新时间
+
+	saveDir string           // 数据文件夹路径
+	decks   map[string]*Deck // 已加载的闪卡包
+	clock   Clock            // 时钟
+	lock    *sync.Mutex
+}
+
+// LoadFilteredDeck 从文件夹 saveDir 路径上加载 id 筛选闪卡包，decks 为已加载的闪卡包，其中属于来源闪卡包的会参与筛选。
+func LoadFilteredDeck(saveDir, id string, decks []*Deck) (ret *FilteredDeck, err error) {
+	return LoadFilteredDeckWithClock(saveDir, id, decks, SystemClock)
+}
+
+// LoadFilteredDeckWithClock 从文件夹 saveDir 路径上加载 id 筛选闪卡包，使用 clock 获取当前时间。
+//
+// decks 的用法同 LoadFilteredDeck，clock 为 nil 时使用系统时钟。
+func LoadFilteredDeckWithClock(saveDir, id string, decks []*Deck, clock Clock) (ret *FilteredDeck, err error) {
+	if nil == clock {
+		clock = SystemClock
+	}
+
+	now := clock.Now()
+	ret = &FilteredDeck{
+		ID:          id,
+		Name:        id,
+		Query:       &FilterQuery{},
+		ShuffleSeed: now.UnixNano(),
+		Created:     now.UnixMilli(),
+		Updated:     now.UnixMilli(),
+		saveDir:     saveDir,
+		decks:       map[string]*Deck{},
+		clock:       clock,
+		lock:        &sync.Mutex{},
+	}
+	for _, deck := range decks {
+		ret.decks[deck.ID] = deck
+	}
+
+	if _, err = readFileWithBackups(getFilteredDeckMsgpackPath(saveDir, id), func(data []byte) (err error) {
+		loaded := *ret
+		if err = msgpack.Unmarshal(data, &loaded); nil == err {
+			*ret = loaded
+		}
+		return
+	}); nil != err {
+		logging.LogErrorf("load filtered deck [%s] failed: %s", id, err)
+		return
+	}
+	if nil == ret.Query {
+		ret.Query = &FilterQuery{}
+	}
+	return
+}
+
+// Save 保存筛选闪卡包的定义，不会保存来源闪卡包。
+func (filtered *FilteredDeck) Save() (err error) {
+	filtered.lock.Lock()
+	defer filtered.lock.Unlock()
+
+	filtered.Updated = filtered.clock.Now().UnixMilli()
+	data, err := msgpack.Marshal(filtered)
+	if nil != err {
+		logging.LogErrorf("save filtered deck failed: %s", err)
+		return
+	}
+	if err = writeFileAtomic(getFilteredDeckMsgpackPath(filtered.saveDir, filtered.ID), data, defaultBackups); nil != err {
+		logging.LogErrorf("save filtered deck failed: %s", err)
+		return
+	}
+	return
+}
+
+// Cards 返回满足查询条件的闪卡，按查询条件的排序策略排序。
+func (filtered *FilteredDeck) Cards() (ret []Card) {
+	filtered.lock.Lock()
+	defer filtered.lock.Unlock()
+
+	query := filtered.Query
+	now := filtered.clock.Now()
+	retrievabilities := map[string]float64{}
+	for _, deck := range filtered.homeDecks() {
+		blockIDs := query.BlockIDs
+		if 1 > len(blockIDs) {
+			blockIDs = deck.GetBlockIDs()
+		}
+		for _, card := range deck.GetCardsByBlockIDs(blockIDs) {
+			if _, ok := retrievabilities[card.ID()]; ok {
+				continue
+			}
+
+			r := deck.Retrievability(card.ID(), now)
+			if !query.match(card, r, now) {
+				continue
+			}
+			retrievabilities[card.ID()] = r
+			ret = append(ret, card)
+		}
+	}
+
+	seed := filtered.ShuffleSeed
+	if 0 != query.Seed {
+		seed = query.Seed
+	}
+	sortCards(ret, query.Order, seed, now, func(card Card) float64 { return retrievabilities[card.ID()] })
+	if 0 < query.Limit && query.Limit < len(ret) {
+		ret = ret[:query.Limit]
+	}
+	return
+}
+
+// HomeDeck 返回闪卡 cardID 所在的来源闪卡包，没有找到时返回 nil。
+func (filtered *FilteredDeck) HomeDeck(cardID string) *Deck {
+	filtered.lock.Lock()
+	defer filtered.lock.Unlock()
+
+	return filtered.homeDeck(cardID)
+}
+
+// Review 使用评分 rating 复习闪卡 cardID，更新来源闪卡包中的闪卡并将复习日志保存到来源闪卡包。
+//
+// 复习可以在来源闪卡包上撤销。
+func (filtered *FilteredDeck) Review(cardID string, rating Rating) (ret *Log, err error) {
+	filtered.lock.Lock()
+	deck := filtered.homeDeck(cardID)
+	filtered.lock.Unlock()
+	if nil == deck {
+		err = errors.New("not found card [" + cardID + "] in filtered deck [" + filtered.ID + "]")
+		return
+	}
+
+	if ret = deck.Review(cardID, rating); nil == ret {
+		err = errors.New("review card [" + cardID + "] failed")
+		return
+	}
+	err = deck.SaveLog(ret)
+	return
+}
+
+// SetClock 设置筛选闪卡包获取当前时间使用的时钟，clock 为 nil 时使用系统时钟。
+func (filtered *FilteredDeck) SetClock(clock Clock) {
+	filtered.lock.Lock()
+	defer filtered.lock.Unlock()
+
+	if nil == clock {
+		clock = SystemClock
+	}
+	filtered.clock = clock
+}
+
+// homeDecks 返回已加载的来源闪卡包，按 DeckIDs 的顺序，调用方需要持有 filtered.lock。
+func (filtered *FilteredDeck) homeDecks() (ret []*Deck) {
+	for _, id := range filtered.DeckIDs {
+		if deck := filtered.decks[id]; nil != deck && !slices.Contains(ret, deck) {
+			ret = append(ret, deck)
+		}
+	}
+	return
+}
+
+// homeDeck 返回闪卡 cardID 所在的来源闪卡包，调用方需要持有 filtered.lock。
+func (filtered *FilteredDeck) homeDeck(cardID string) *Deck {
+	for _, deck := range filtered.homeDecks() {
+		if nil != deck.GetCard(cardID) {
+			return deck
+		}
+	}
+	return nil
+}
+
+// match 判断闪卡 card 在 now 时是否满足查询条件，r 为闪卡的可提取性，新卡为 0。
+func (query *FilterQuery) match(card Card, r float64, now time.Time) bool {
+	if !query.QueryOptions.match(card, now) {
+		return false
+	}
+	if 0 < len(query.States) && !slices.Contains(query.States, card.GetState()) {
+		return false
+	}
+	if query.MinLapses > card.GetLapses() {
+		return false
+	}
+	if 0 < query.MinRetrievability && query.MinRetrievability > r {
+		return false
+	}
+	if 0 < query.MaxRetrievability && (card.GetLastReview().IsZero() || query.MaxRetrievability < r) {
+		return false
+	}
+	if query.DueOnly && now.Before(card.GetDue()) {
+		return false
+	}
+	return true
+}
+
+func getFilteredDeckMsgpackPath(saveDir, id string) string {
+	return filepath.Join(saveDir, id+".filter")
+}
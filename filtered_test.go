@@ -0,0 +1,120 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFilteredDeck(t *testing.T) {
+	const saveDir = "testdata"
+	os.MkdirAll(saveDir, 0755)
+	defer os.RemoveAll(saveDir)
+
+	clock := NewManualClock(time.Date(2023, 5, 20, 8, 0, 0, 0, time.Local))
+	var decks []*Deck
+	hard := map[string]string{} // 遗忘过的闪卡 ID 到所在闪卡包 ID
+	for i := 0; i < 3; i++ {
+		deck, err := LoadDeckWithClock(saveDir, newID(), nil, clock)
+		if nil != err {
+			t.Fatal(err)
+		}
+		for j := 0; j < 4; j++ {
+			cardID := newID()
+			deck.AddCard(cardID, newID())
+			deck.AddTags(cardID, "math")
+			deck.Review(cardID, Good)
+			if 0 == j%2 {
+				clock.Advance(3 * 24 * time.Hour)
+				deck.Review(cardID, Good)
+				deck.Review(cardID, Again)
+				hard[cardID] = deck.ID
+			}
+		}
+		// 没有标签的闪卡不会被筛选
+		cardID := newID()
+		deck.AddCard(cardID, newID())
+		deck.Review(cardID, Again)
+		deck.Review(cardID, Again)
+		decks = append(decks, deck)
+	}
+
+	filteredID := newID()
+	filtered, err := LoadFilteredDeckWithClock(saveDir, filteredID, decks, clock)
+	if nil != err {
+		t.Fatal(err)
+	}
+	filtered.DeckIDs = []string{decks[0].ID, decks[1].ID}
+	filtered.Query = &FilterQuery{QueryOptions: QueryOptions{Tags: []string{"math"}, Order: DueOrderRetrievability}, MinLapses: 1}
+	if err = filtered.Save(); nil != err {
+		t.Fatal(err)
+	}
+
+	// 重新加载保存的查询条件
+	if filtered, err = LoadFilteredDeckWithClock(saveDir, filteredID, decks, clock); nil != err {
+		t.Fatal(err)
+	}
+	cards := filtered.Cards()
+	if 4 != len(cards) {
+		t.Fatalf("filtered cards len [%d] != [4]", len(cards))
+	}
+	for _, card := range cards {
+		if deckID := hard[card.ID()]; "" == deckID || decks[2].ID == deckID {
+			t.Fatalf("card [%s] should not be filtered", card.ID())
+		}
+	}
+
+	filtered.Query.Limit = 1
+	if 1 != len(filtered.Cards()) {
+		t.Fatal("filtered cards not limited")
+	}
+
+	card := cards[0]
+	home := filtered.HomeDeck(card.ID())
+	if nil == home || hard[card.ID()] != home.ID {
+		t.Fatalf("home deck of card [%s] mismatched", card.ID())
+	}
+	reps := card.GetReps()
+	log, err := filtered.Review(card.ID(), Good)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if home.ID != log.DeckID || reps+1 != home.GetCard(card.ID()).GetReps() {
+		t.Fatal("review in filtered deck should update the home deck")
+	}
+	found := false
+	if err = home.Logs(&LogQuery{CardIDs: []string{card.ID()}}, func(l *Log) bool {
+		found = found || log.ID == l.ID
+		return true
+	}); nil != err {
+		t.Fatal(err)
+	}
+	if !found {
+		t.Fatal("review log not saved to the home deck")
+	}
+
+	for cardID, deckID := range hard {
+		if decks[2].ID == deckID {
+			if _, err = filtered.Review(cardID, Good); nil == err {
+				t.Fatal("card outside the filtered deck should not be reviewed")
+			}
+			break
+		}
+	}
+}
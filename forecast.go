@@ -0,0 +1,165 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/siyuan-note/logging"
+)
+
+// ForecastOptions 描述了复习量预测的选项。
+type ForecastOptions struct {
+	// Simulate 是否模拟复习，模拟时到期的闪卡按预期的评分复习，继续预测这些复习产生的后续复习。
+	//
+	// 每次复习是否回忆成功按当时的可提取性抽样，回忆失败时评分为 Again，回忆成功时按 RecallRatingProbs 抽样 Hard、Good 或者 Easy，
+	// 模拟 Runs 次后取每天复习数的平均值。
+	Simulate          bool
+	RecallRatingProbs [3]float64 // 回忆成功时 Hard、Good、Easy 的概率，不需要归一化，全为 0 时使用默认值
+	Runs              int        // 模拟次数，为 0 时默认 20 次
+	Seed              int64      // 随机数种子，相同的种子和闪卡得到相同的预测
+}
+
+const (
+	maxSimulatedReviews = 1000 // 模拟复习时单张闪卡最多复习的次数，避免短间隔的学习步骤耗时过长
+	defaultForecastRuns = 20   // 默认的模拟次数
+)
+
+// DefaultRecallRatingProbs 返回模拟复习时回忆成功后 Hard、Good、Easy 的默认概率。
+func DefaultRecallRatingProbs() [3]float64 {
+	return [3]float64{0.3, 0.6, 0.1}
+}
+
+// Forecast 返回从今天开始 days 天内每天到期的复习数，已经过期的闪卡计入今天，搁置的闪卡计入搁置结束的那天，新卡和暂停的闪卡不计入。
+func (deck *Deck) Forecast(days int) ([]int, error) {
+	return deck.ForecastWithOptions(days, nil)
+}
+
+// ForecastWithOptions 使用预测选项 opts 返回从今天开始 days 天内每天的复习数。
+//
+// 模拟模式下到期的闪卡会按预期的评分使用闪卡包存储的调度算法复习，这些复习产生的后续复习也会计入预测。
+func (deck *Deck) ForecastWithOptions(days int, opts *ForecastOptions) (ret []int, err error) {
+	deck.lock.Lock()
+	defer deck.lock.Unlock()
+
+	now := deck.clock.Now()
+	if err = deck.store.WithScheduler(func(scheduler Scheduler, cards []Card) error {
+		ret = forecast(cards, scheduler, now, days, opts)
+		return nil
+	}); nil != err {
+		logging.LogErrorf("forecast deck [%s] failed: %s", deck.Name, err)
+	}
+	return
+}
+
+// forecast 返回按 ID 排序的闪卡 cards 从 now 所在的那天开始 days 天内每天的复习数，模拟复习时使用调度算法 scheduler 复习闪卡的克隆。
+func forecast(cards []Card, scheduler Scheduler, now time.Time, days int, opts *ForecastOptions) (ret []int) {
+	if 1 > days {
+		return []int{}
+	}
+	if nil == opts {
+		opts = &ForecastOptions{}
+	}
+	runs := opts.Runs
+	if !opts.Simulate {
+		runs = 1
+	} else if 1 > runs {
+		runs = defaultForecastRuns
+	}
+	probs := opts.RecallRatingProbs
+	if 0 >= probs[0]+probs[1]+probs[2] {
+		probs = DefaultRecallRatingProbs()
+	}
+	random := rand.New(rand.NewSource(opts.Seed))
+
+	today := getDayStart(now)
+	end := today.AddDate(0, 0, days)
+	counts := make([]int, days)
+	for _, card := range cards {
+		if card.GetLastReview().IsZero() || card.IsSuspended() {
+			continue // 新卡还没有开始学习，暂停的闪卡不参与复习，都不计入复习量
+		}
+
+		// 搁置的闪卡在搁置结束之后才会复习
+		due := maxTime(card.GetDue(), card.GetBuriedUntil())
+		if !due.Before(end) {
+			continue
+		}
+		if !opts.Simulate {
+			counts[getForecastDay(today, due)]++
+			continue
+		}
+
+		for run := 0; run < runs; run++ {
+			simulated := card.Clone()
+			if nil == simulated {
+				break
+			}
+			for i, simulatedDue := 0, due; i < maxSimulatedReviews && simulatedDue.Before(end); i++ {
+				counts[getForecastDay(today, simulatedDue)]++
+				at := maxTime(simulatedDue, now)
+				rating := Again
+				if random.Float64() < retrievability(scheduler, simulated, at) {
+					rating = Hard + Rating(SampleProbs(random, probs[:]))
+				}
+				scheduler.Repeat(simulated, at, rating)
+				simulatedDue = simulated.GetDue()
+			}
+		}
+	}
+
+	ret = make([]int, days)
+	for i, count := range counts {
+		ret[i] = int(math.Round(float64(count) / float64(runs)))
+	}
+	return
+}
+
+// SampleProbs 使用随机数生成器 random 按概率 probs 抽样并返回下标，probs 不需要归一化，比如模拟复习时抽样评分。
+func SampleProbs(random *rand.Rand, probs []float64) int {
+	total := 0.0
+	for _, p := range probs {
+		total += p
+	}
+	r := random.Float64() * total
+	for i, p := range probs {
+		if r < p {
+			return i
+		}
+		r -= p
+	}
+	return len(probs) - 1
+}
+
+// getForecastDay 返回 due 距离 today 零点的天数，已经过期的闪卡计入第 0 天。
+func getForecastDay(today, due time.Time) int {
+	if due.Before(today) {
+		return 0
+	}
+	due = due.In(today.Location())
+	day := time.Date(due.Year(), due.Month(), due.Day(), 0, 0, 0, 0, today.Location())
+	return int(math.Round(day.Sub(today).Hours() / 24))
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,109 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestForecast(t *testing.T) {
+	const saveDir = "testdata"
+	os.MkdirAll(saveDir, 0755)
+	defer os.RemoveAll(saveDir)
+
+	now := time.Date(2023, 5, 20, 8, 0, 0, 0, time.Local)
+	deck, err := LoadDeckWithClock(saveDir, newID(), nil, NewManualClock(now))
+	if nil != err {
+		t.Fatal(err)
+	}
+	deck.AddCard(newID(), newID()) // 新卡不计入
+	for _, due := range []time.Time{now.AddDate(0, 0, -1), now.AddDate(0, 0, 2), now.AddDate(0, 0, 10)} {
+		cardID := newID()
+		deck.AddCard(cardID, newID())
+		deck.Review(cardID, Good)
+		card := deck.GetCard(cardID)
+		card.SetDue(due)
+		deck.SetCard(card)
+	}
+
+	// 搁置到后天的闪卡计入后天
+	buriedID := newID()
+	deck.AddCard(buriedID, newID())
+	deck.Review(buriedID, Good)
+	buried := deck.GetCard(buriedID)
+	buried.SetDue(now)
+	deck.SetCard(buried)
+	deck.Bury(buriedID, getDayStart(now).AddDate(0, 0, 2))
+
+	forecastWith := func(days int, opts *ForecastOptions) []int {
+		ret, err := deck.ForecastWithOptions(days, opts)
+		if nil != err {
+			t.Fatal(err)
+		}
+		return ret
+	}
+	forecast, err := deck.Forecast(7)
+	if nil != err {
+		t.Fatal(err)
+	}
+	expected := []int{1, 0, 2, 0, 0, 0, 0}
+	for i := range expected {
+		if expected[i] != forecast[i] {
+			t.Fatalf("forecast %v != %v", forecast, expected)
+		}
+	}
+
+	simulated := forecastWith(7, &ForecastOptions{Simulate: true})
+	total := 0
+	for i := range simulated {
+		if simulated[i] < forecast[i] {
+			t.Fatalf("simulated forecast %v less than %v", simulated, forecast)
+		}
+		total += simulated[i]
+	}
+	if 3 >= total {
+		t.Fatalf("simulated forecast %v has no follow-up reviews", simulated)
+	}
+
+	// 相同的种子得到相同的预测，回忆成功时评分越高后续复习越少
+	sum := func(forecast []int) (ret int) {
+		for _, count := range forecast {
+			ret += count
+		}
+		return
+	}
+	if sum(simulated) != sum(forecastWith(7, &ForecastOptions{Simulate: true})) {
+		t.Fatal("simulated forecast not reproducible")
+	}
+	hard := forecastWith(30, &ForecastOptions{Simulate: true, RecallRatingProbs: [3]float64{1, 0, 0}})
+	easy := forecastWith(30, &ForecastOptions{Simulate: true, RecallRatingProbs: [3]float64{0, 0, 1}})
+	if sum(easy) >= sum(hard) {
+		t.Fatalf("easy forecast %v not less than hard forecast %v", easy, hard)
+	}
+}
+
+func TestGetForecastDay(t *testing.T) {
+	// 到期时间和今天在不同的时区，按今天的时区计算日期
+	tokyo, utc := time.FixedZone("UTC+9", 9*60*60), time.UTC
+	today := time.Date(2023, 5, 20, 0, 0, 0, 0, tokyo)
+	due := time.Date(2023, 5, 20, 20, 0, 0, 0, utc) // 东京时间 5 月 21 日 5 点
+	if day := getForecastDay(today, due); 1 != day {
+		t.Fatalf("forecast day [%d] != [1]", day)
+	}
+}
@@ -0,0 +1,125 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/open-spaced-repetition/go-fsrs/v3"
+	"github.com/siyuan-note/logging"
+)
+
+// FSRSScheduler 描述了 FSRS 算法调度器。
+type FSRSScheduler struct {
+	fsrs *fsrs.FSRS
+}
+
+// NewFSRSScheduler 使用以逗号分隔的算法参数 weights 新建 FSRS 算法调度器，参数无效时记录日志并使用默认参数。
+func NewFSRSScheduler(requestRetention float64, maximumInterval int, weights string) *FSRSScheduler {
+	ret, _ := NewFSRSSchedulerWithParams(parseFSRSParams(requestRetention, maximumInterval, weights))
+	return ret
+}
+
+// parseFSRSParams 返回旧的接口传入的 FSRS 算法参数，weights 为以逗号分隔的算法参数，参数无效时记录日志并返回默认参数。
+func parseFSRSParams(requestRetention float64, maximumInterval int, weights string) (ret *FSRSParams) {
+	ret = &FSRSParams{RequestRetention: requestRetention, MaximumInterval: maximumInterval}
+	var err error
+	if ret.Weights, err = ParseWeights(weights); nil == err {
+		if err = ret.Validate(); nil == err {
+			return
+		}
+	}
+
+	logging.LogErrorf("invalid fsrs params: %s, use default params instead", err)
+	ret = &DefaultDeckOptions().FSRS
+	return
+}
+
+// NewFSRSSchedulerWithParams 使用算法参数 params 新建 FSRS 算法调度器，参数无效时返回错误。
+func NewFSRSSchedulerWithParams(params *FSRSParams) (ret *FSRSScheduler, err error) {
+	if err = params.Validate(); nil != err {
+		return
+	}
+
+	p := fsrs.DefaultParam()
+	p.RequestRetention = params.RequestRetention
+	p.MaximumInterval = float64(params.MaximumInterval)
+	if 0 < len(params.Weights) {
+		p.W = toFSRSWeights(params.Weights)
+	}
+	ret = &FSRSScheduler{fsrs: fsrs.NewFSRS(p)}
+	return
+}
+
+// toFSRSWeights 将算法参数转换为 go-fsrs 的参数，不足的部分（比如 17 个参数时的 w17 和 w18）使用 go-fsrs 的默认参数。
+func toFSRSWeights(weights []float64) (ret fsrs.Weights) {
+	ret = fsrs.DefaultParam().W
+	copy(ret[:], weights)
+	return
+}
+
+// formatWeights 将 FSRS 算法参数格式化为以逗号分隔的字符串。
+func formatWeights(weights fsrs.Weights) string {
+	var buf []string
+	for _, w := range weights {
+		buf = append(buf, strconv.FormatFloat(w, 'f', 4, 64))
+	}
+	return strings.Join(buf, ", ")
+}
+
+func (scheduler *FSRSScheduler) Algo() Algo {
+	return AlgoFSRS
+}
+
+func (scheduler *FSRSScheduler) NewCard(id, blockID string) Card {
+	c := fsrs.NewCard()
+	return &FSRSCard{BaseCard: &BaseCard{CID: id, BID: blockID}, C: &c}
+}
+
+func (scheduler *FSRSScheduler) Preview(card Card, now time.Time) (ret map[Rating]time.Time) {
+	c := card.Impl().(*fsrs.Card)
+	ret = map[Rating]time.Time{}
+	for rating, schedulingInfo := range scheduler.fsrs.Repeat(*c, now) {
+		ret[Rating(rating)] = schedulingInfo.Card.Due
+	}
+	return
+}
+
+func (scheduler *FSRSScheduler) Repeat(card Card, now time.Time, rating Rating) (ret *Log) {
+	c := card.Impl().(*fsrs.Card)
+	schedulingInfo := scheduler.fsrs.Next(*c, now, fsrs.Rating(rating))
+	updated := schedulingInfo.Card
+	card.SetImpl(&updated)
+
+	reviewLog := schedulingInfo.ReviewLog
+	ret = &Log{
+		ID:            newIDAt(now),
+		CardID:        card.ID(),
+		Rating:        rating,
+		ScheduledDays: reviewLog.ScheduledDays,
+		ElapsedDays:   reviewLog.ElapsedDays,
+		Reviewed:      reviewLog.Review.Unix(),
+		State:         State(reviewLog.State),
+	}
+	return
+}
+
+func (scheduler *FSRSScheduler) Retrievability(card Card, now time.Time) float64 {
+	return scheduler.fsrs.GetRetrievability(*card.Impl().(*fsrs.Card), now)
+}
@@ -0,0 +1,204 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+)
+
+// 闪卡存储在 cards 之外维护了若干二级索引：内容块 ID 到闪卡的索引、标签到闪卡的索引和按到期时间排序的最小堆。
+// 所有对 cards 的修改都需要通过 putCard 和 deleteCard 进行，闪卡的到期时间变化后需要调用 fixDue，以保持索引一致。
+// 调用方直接修改闪卡到期时间而没有调用 SetCard 或者 SetDue 时，getDueCards 只能修正已经到期的索引项。
+
+// putCard 保存闪卡 card 并更新索引，调用方需要持有 store.lock。
+func (store *BaseStore) putCard(card Card) {
+	store.deleteCard(card.ID())
+	store.cards[card.ID()] = card
+
+	addToIndex(store.blocks, card.BlockID(), card)
+	tags := card.GetTags()
+	for _, tag := range tags {
+		addToIndex(store.tags, tag, card)
+	}
+	store.cardTags[card.ID()] = tags
+
+	item := &dueItem{card: card, due: card.GetDue()}
+	heap.Push(&store.dues, item)
+	store.dueItems[card.ID()] = item
+}
+
+// reindexTags 在闪卡 card 的标签变化之后更新标签索引，调用方需要持有 store.lock。
+func (store *BaseStore) reindexTags(card Card) {
+	for _, tag := range store.cardTags[card.ID()] {
+		removeFromIndex(store.tags, tag, card.ID())
+	}
+	tags := card.GetTags()
+	for _, tag := range tags {
+		addToIndex(store.tags, tag, card)
+	}
+	store.cardTags[card.ID()] = tags
+}
+
+// fixDue 在闪卡 card 的到期时间变化（比如复习）之后更新到期索引，调用方需要持有 store.lock。
+func (store *BaseStore) fixDue(card Card) {
+	item := store.dueItems[card.ID()]
+	if nil == item {
+		return
+	}
+	item.card = card
+	item.due = card.GetDue()
+	heap.Fix(&store.dues, item.index)
+}
+
+// deleteCard 删除 id 闪卡并更新索引，调用方需要持有 store.lock。
+func (store *BaseStore) deleteCard(id string) (ret Card) {
+	ret = store.cards[id]
+	if nil == ret {
+		return
+	}
+	delete(store.cards, id)
+
+	removeFromIndex(store.blocks, ret.BlockID(), id)
+	// 闪卡的标签可能已经被修改，所以按照索引时的标签移除
+	for _, tag := range store.cardTags[id] {
+		removeFromIndex(store.tags, tag, id)
+	}
+	delete(store.cardTags, id)
+
+	if item := store.dueItems[id]; nil != item {
+		heap.Remove(&store.dues, item.index)
+		delete(store.dueItems, id)
+	}
+	return
+}
+
+// resetCards 清空闪卡和索引，调用方需要持有 store.lock。
+func (store *BaseStore) resetCards() {
+	store.cards = map[string]Card{}
+	store.blocks = map[string]map[string]Card{}
+	store.tags = map[string]map[string]Card{}
+	store.cardTags = map[string][]string{}
+	store.dues = dueHeap{}
+	store.dueItems = map[string]*dueItem{}
+}
+
+// getDueCards 按到期时间先后顺序返回在 now 时已经到期的闪卡，调用方需要持有 store.lock。
+//
+// 堆中到期时间晚于 now 的节点的子树都不会到期，所以只需要访问到期的 k 张闪卡及其子节点，耗时 O(k log k)。
+// 闪卡的到期时间可能被直接修改而没有更新索引，所以访问到的闪卡以闪卡本身的到期时间为准，并修正过期的索引项。
+func (store *BaseStore) getDueCards(now time.Time) (ret []Card) {
+	var items, stales []*dueItem
+	stack := []int{0}
+	for 0 < len(stack) {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if i >= len(store.dues) || now.Before(store.dues[i].due) {
+			continue
+		}
+
+		item := store.dues[i]
+		if !item.due.Equal(item.card.GetDue()) {
+			stales = append(stales, item)
+		}
+		if !now.Before(item.card.GetDue()) {
+			items = append(items, item)
+		}
+		stack = append(stack, 2*i+1, 2*i+2)
+	}
+	for _, item := range stales {
+		store.fixDue(item.card)
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].card.GetDue().Before(items[j].card.GetDue()) })
+	for _, item := range items {
+		ret = append(ret, item.card)
+	}
+	return
+}
+
+func addToIndex(index map[string]map[string]Card, key string, card Card) {
+	cards := index[key]
+	if nil == cards {
+		cards = map[string]Card{}
+		index[key] = cards
+	}
+	cards[card.ID()] = card
+}
+
+func removeFromIndex(index map[string]map[string]Card, key, id string) {
+	if cards := index[key]; nil != cards {
+		delete(cards, id)
+		if 1 > len(cards) {
+			delete(index, key)
+		}
+	}
+}
+
+// getCardsByBlockIDs 返回 blockIDs 中各个内容块关联的闪卡，filter 不为 nil 时只返回满足条件的闪卡，调用方需要持有 store.lock。
+func (store *BaseStore) getCardsByBlockIDs(blockIDs []string, filter func(card Card) bool) (ret []Card) {
+	visited := map[string]bool{}
+	for _, blockID := range blockIDs {
+		if visited[blockID] {
+			continue
+		}
+		visited[blockID] = true
+
+		for _, card := range store.blocks[blockID] {
+			if nil == filter || filter(card) {
+				ret = append(ret, card)
+			}
+		}
+	}
+	return
+}
+
+// dueItem 描述了到期索引中的一张闪卡。
+type dueItem struct {
+	card  Card
+	due   time.Time
+	index int // 在堆中的位置
+}
+
+// dueHeap 是按到期时间排序的最小堆，实现了 heap.Interface。
+type dueHeap []*dueItem
+
+func (h dueHeap) Len() int { return len(h) }
+
+func (h dueHeap) Less(i, j int) bool { return h[i].due.Before(h[j].due) }
+
+func (h dueHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *dueHeap) Push(x any) {
+	item := x.(*dueItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *dueHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
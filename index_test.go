@@ -0,0 +1,137 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBlockIndex(t *testing.T) {
+	const saveDir = "testdata"
+	os.MkdirAll(saveDir, 0755)
+	defer os.RemoveAll(saveDir)
+
+	const storeID = "test-index"
+	store := NewFSRSStore(storeID, saveDir, requestRetention, maximumInterval, weights)
+	block0, block1 := newID(), newID()
+	card0, card1, card2 := newID(), newID(), newID()
+	store.AddCard(card0, block0)
+	store.AddCard(card1, block0)
+	store.AddCard(card2, block1)
+	store.Review(card1, Good)
+
+	if cards := store.GetCardsByBlockIDs([]string{block0, block1, block0}); 3 != len(cards) {
+		t.Fatalf("cards len [%d] != [3]", len(cards))
+	}
+	if cards := store.GetNewCardsByBlockIDs([]string{block0}); 1 != len(cards) || card0 != cards[0].ID() {
+		t.Fatalf("new cards of block [%s] mismatched", block0)
+	}
+
+	// 替换为关联其他内容块的闪卡后旧的内容块不再关联该闪卡
+	moved := store.GetCard(card0).(*FSRSCard)
+	store.SetCard(&FSRSCard{BaseCard: &BaseCard{CID: card0, BID: block1}, C: moved.C})
+	if cards := store.GetCardsByBlockID(block0); 1 != len(cards) || card1 != cards[0].ID() {
+		t.Fatalf("cards of block [%s] mismatched", block0)
+	}
+	store.RemoveCard(card1)
+	if blockIDs := store.GetBlockIDs(); 1 != len(blockIDs) || block1 != blockIDs[0] {
+		t.Fatalf("block IDs %v mismatched", blockIDs)
+	}
+
+	if err := store.Save(); nil != err {
+		t.Fatal(err)
+	}
+	store.RemoveCard(card2)
+	loaded := NewFSRSStore(storeID, saveDir, requestRetention, maximumInterval, weights)
+	if err := loaded.Load(); nil != err {
+		t.Fatal(err)
+	}
+	if cards := loaded.GetCardsByBlockID(block1); 1 != len(cards) || card0 != cards[0].ID() {
+		t.Fatalf("cards of block [%s] mismatched after load", block1)
+	}
+}
+
+func TestDueIndex(t *testing.T) {
+	store := NewFSRSStore("test-due-index", "testdata", requestRetention, maximumInterval, weights)
+	now := time.Now()
+	var ids []string
+	for i := 0; i < 64; i++ {
+		id := newID()
+		ids = append(ids, id)
+		card := store.AddCard(id, newID())
+		card.SetDue(now.Add(time.Duration(i-32) * time.Hour))
+		store.SetCard(card)
+	}
+
+	dues := store.Dues()
+	if 33 != len(dues) {
+		t.Fatalf("dues len [%d] != [33]", len(dues))
+	}
+	for i := 1; i < len(dues); i++ {
+		if dues[i].GetDue().Before(dues[i-1].GetDue()) {
+			t.Fatal("dues not ordered by due time")
+		}
+	}
+	if dues[0].NextDues()[Good].IsZero() {
+		t.Fatal("next dues not computed")
+	}
+
+	// 复习和移除之后不再到期
+	store.Review(ids[0], Good)
+	store.RemoveCard(ids[1])
+	if dues = store.Dues(); 31 != len(dues) || ids[2] != dues[0].ID() {
+		t.Fatalf("dues len [%d] != [31] after review", len(dues))
+	}
+
+	// 通过存储设置到期时间会更新索引
+	store.SetDue(ids[63], now.Add(-time.Hour))
+	if dues = store.Dues(); 32 != len(dues) {
+		t.Fatalf("dues len [%d] != [32] after set due", len(dues))
+	}
+
+	// 直接修改闪卡推迟到期时间，到期的闪卡以闪卡本身的到期时间为准
+	store.GetCard(ids[2]).SetDue(now.Add(time.Hour))
+	if dues = store.Dues(); 31 != len(dues) || ids[2] == dues[0].ID() {
+		t.Fatalf("dues len [%d] != [31] after postponing due", len(dues))
+	}
+	if dues = store.GetDueCardsByBlockIDs(store.GetBlockIDs()); 31 != len(dues) {
+		t.Fatalf("due cards len [%d] != [31] after postponing due", len(dues))
+	}
+}
+
+func TestDuesNextDues(t *testing.T) {
+	store := NewFSRSStore("test-dues-next-dues", "testdata", requestRetention, maximumInterval, weights)
+	now := time.Date(2023, 5, 20, 8, 0, 0, 0, time.Local)
+	store.SetClock(NewManualClock(now))
+	store.AddCard(newID(), newID())
+
+	dues := store.Dues()
+	expected := store.scheduler.Preview(dues[0].Clone(), now)
+
+	// 查询时已经计算好下次到期时间，克隆也会带上
+	if clone := dues[0].Clone(); !expected[Good].Equal(clone.NextDues()[Good]) {
+		t.Fatalf("cloned next dues [%s] != [%s]", clone.NextDues()[Good], expected[Good])
+	}
+
+	// 复习之后再取下次到期时间，仍然是查询时的预览
+	store.ReviewAt(dues[0].ID(), Easy, now)
+	if nextDues := dues[0].NextDues(); !expected[Good].Equal(nextDues[Good]) {
+		t.Fatalf("next dues [%s] != [%s]", nextDues[Good], expected[Good])
+	}
+}
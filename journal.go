@@ -0,0 +1,162 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"path/filepath"
+
+	"github.com/siyuan-note/filelock"
+	"github.com/siyuan-note/logging"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// 预写日志（<id>.journal）记录了上次保存之后的每一次闪卡变更，加载时在快照（<id>.cards）之上重放。
+//
+// 保存快照成功后，保留备份时预写日志被重命名为 <id>.journal.bak1，它记录了最新的备份（<id>.cards.bak1）到当前快照之间的变更，
+// 快照损坏需要从最新的备份加载时依次重放这两份日志，不保留备份时直接清空。
+
+const journalFileExt = ".journal"
+
+// journalOp 描述了预写日志中的闪卡变更操作。
+type journalOp string
+
+const (
+	journalAdd    journalOp = "add"    // 添加闪卡
+	journalRemove journalOp = "remove" // 移除闪卡
+	journalReview journalOp = "review" // 复习闪卡
+	journalSet    journalOp = "set"    // 设置闪卡（SetCard）或者修改到期时间（SetDue）
+)
+
+// journalEntry 描述了预写日志中的一条记录，Card 为变更后的完整闪卡，所以重放是幂等的。
+type journalEntry struct {
+	Op   journalOp
+	ID   string
+	Card msgpack.RawMessage
+}
+
+// appendJournal 将闪卡变更追加到预写日志，调用方需要持有 store.lock。
+func (store *BaseStore) appendJournal(op journalOp, id string, card Card) {
+	entry := &journalEntry{Op: op, ID: id}
+	if nil != card {
+		data, err := msgpack.Marshal(card)
+		if nil != err {
+			logging.LogErrorf("marshal card [%s] for journal failed: %s", id, err)
+			return
+		}
+		entry.Card = data
+	}
+
+	if err := appendRecords(store.getJournalPath(), entry); nil != err {
+		logging.LogErrorf("append journal [op=%s, id=%s] failed: %s", op, id, err)
+	}
+}
+
+// replayJournal 在内存中的闪卡上重放预写日志，generation 为加载的快照的备份代数，调用方需要持有 store.lock。
+func (store *BaseStore) replayJournal(generation int) (err error) {
+	if 1 < generation {
+		logging.LogWarnf("store [%s] loaded from backup [%d], changes saved after it are lost", store.id, generation)
+	}
+	for _, p := range getJournalPaths(store.saveDir, store.id, generation) {
+		if err = store.replayJournalFile(p); nil != err {
+			return
+		}
+	}
+	return
+}
+
+func (store *BaseStore) replayJournalFile(p string) (err error) {
+	count := 0
+	var unmarshalErr error
+	err = readJournal(p, func(entry *journalEntry) bool {
+		count++
+		if journalRemove == entry.Op {
+			store.deleteCard(entry.ID)
+			return true
+		}
+
+		card := store.scheduler.NewCard(entry.ID, "")
+		if unmarshalErr = msgpack.Unmarshal(entry.Card, card); nil != unmarshalErr {
+			return false
+		}
+		store.putCard(card)
+		return true
+	})
+	if nil == err {
+		err = unmarshalErr
+	}
+	if nil != err {
+		logging.LogErrorf("replay journal [%s] failed: %s", p, err)
+		return
+	}
+	if 0 < count {
+		logging.LogInfof("replayed [%d] journal entries of store [%s] from [%s]", count, store.id, filepath.Base(p))
+	}
+	return
+}
+
+// readJournal 按写入顺序读取预写日志 p 中的记录，fn 返回 false 时停止读取。
+func readJournal(p string, fn func(entry *journalEntry) bool) (err error) {
+	var unmarshalErr error
+	err = readRecords(p, func(data []byte) bool {
+		entry := &journalEntry{}
+		if unmarshalErr = msgpack.Unmarshal(data, entry); nil != unmarshalErr {
+			return false
+		}
+		return fn(entry)
+	})
+	if nil == err {
+		err = unmarshalErr
+	}
+	return
+}
+
+// truncateJournal 清空预写日志，保留备份时将其重命名为最新备份对应的日志，调用方需要持有 store.lock。
+func (store *BaseStore) truncateJournal() (err error) {
+	p := store.getJournalPath()
+	backup := getBackupPath(p, 1)
+	if 0 < store.backups {
+		if filelock.IsExist(p) {
+			err = filelock.Rename(p, backup)
+		} else if filelock.IsExist(backup) {
+			// 上次保存之后没有变更，最新的备份和当前快照相同
+			err = filelock.Remove(backup)
+		}
+	} else if filelock.IsExist(p) {
+		err = filelock.Remove(p)
+	}
+	if nil != err {
+		logging.LogErrorf("truncate journal failed: %s", err)
+	}
+	return
+}
+
+func (store *BaseStore) getJournalPath() string {
+	return getJournalPath(store.saveDir, store.id)
+}
+
+func getJournalPath(saveDir, id string) string {
+	return filepath.Join(saveDir, id+journalFileExt)
+}
+
+// getJournalPaths 返回加载第 generation 代快照后需要依次重放的预写日志，第 1 代备份之后的变更记录在日志的备份中。
+func getJournalPaths(saveDir, id string, generation int) []string {
+	p := getJournalPath(saveDir, id)
+	if 1 == generation {
+		return []string{getBackupPath(p, 1), p}
+	}
+	return []string{p}
+}
@@ -0,0 +1,166 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestJournal(t *testing.T) {
+	const saveDir = "testdata"
+	os.MkdirAll(saveDir, 0755)
+	defer os.RemoveAll(saveDir)
+
+	const storeID = "test-journal"
+	store := NewFSRSStore(storeID, saveDir, requestRetention, maximumInterval, weights)
+	savedID, reviewedID, removedID, dueID, setDueID := newID(), newID(), newID(), newID(), newID()
+	store.AddCard(savedID, newID())
+	if err := store.Save(); nil != err {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(store.getJournalPath()); !os.IsNotExist(err) {
+		t.Fatal("journal should be truncated after save")
+	}
+
+	// 保存之后的变更只存在于预写日志中
+	store.AddCard(reviewedID, newID())
+	store.AddCard(removedID, newID())
+	store.AddCard(dueID, newID())
+	store.AddCard(setDueID, newID())
+	store.Review(reviewedID, Good)
+	store.RemoveCard(removedID)
+	due := time.Now().AddDate(0, 0, 3).Truncate(time.Second)
+	card := store.GetCard(dueID)
+	card.SetDue(due)
+	store.SetCard(card)
+	store.SetDue(setDueID, due)
+
+	// 模拟写入时崩溃留下的不完整记录
+	f, _ := os.OpenFile(store.getJournalPath(), os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write([]byte{0, 0, 0, 16, 0x83})
+	f.Close()
+
+	loaded := NewFSRSStore(storeID, saveDir, requestRetention, maximumInterval, weights)
+	if err := loaded.Load(); nil != err {
+		t.Fatal(err)
+	}
+	if 4 != loaded.CountCards() {
+		t.Fatalf("cards count [%d] != [4]", loaded.CountCards())
+	}
+	if nil != loaded.GetCard(removedID) {
+		t.Fatalf("removed card [%s] replayed", removedID)
+	}
+	if 1 != loaded.GetCard(reviewedID).GetReps() {
+		t.Fatalf("reviewed card reps [%d] != [1]", loaded.GetCard(reviewedID).GetReps())
+	}
+	if !due.Equal(loaded.GetCard(dueID).GetDue()) {
+		t.Fatalf("card due [%s] != [%s]", loaded.GetCard(dueID).GetDue(), due)
+	}
+	if !due.Equal(loaded.GetCard(setDueID).GetDue()) {
+		t.Fatalf("card due [%s] != [%s]", loaded.GetCard(setDueID).GetDue(), due)
+	}
+
+	// 崩溃之后的变更追加在完整的记录之后，再次加载不会失败
+	addedID := newID()
+	loaded.AddCard(addedID, newID())
+	reloaded := NewFSRSStore(storeID, saveDir, requestRetention, maximumInterval, weights)
+	if err := reloaded.Load(); nil != err {
+		t.Fatal(err)
+	}
+	if 5 != reloaded.CountCards() || nil == reloaded.GetCard(addedID) {
+		t.Fatalf("cards count [%d] != [5]", reloaded.CountCards())
+	}
+}
+
+func TestJournalWithBackup(t *testing.T) {
+	const saveDir = "testdata"
+	os.MkdirAll(saveDir, 0755)
+	defer os.RemoveAll(saveDir)
+
+	const storeID = "test-journal-backup"
+	store := NewFSRSStore(storeID, saveDir, requestRetention, maximumInterval, weights)
+	reviewedID, savedID, addedID := newID(), newID(), newID()
+	store.AddCard(reviewedID, newID())
+	if err := store.Save(); nil != err {
+		t.Fatal(err)
+	}
+	store.Review(reviewedID, Good)
+	store.AddCard(savedID, newID())
+	if err := store.Save(); nil != err {
+		t.Fatal(err)
+	}
+	store.AddCard(addedID, newID())
+
+	// 快照损坏时从最新的备份加载，最后一次保存的变更和之后的变更都不会丢失
+	if err := os.WriteFile(store.getMsgPackPath(), []byte("corrupted"), 0644); nil != err {
+		t.Fatal(err)
+	}
+	loaded := NewFSRSStore(storeID, saveDir, requestRetention, maximumInterval, weights)
+	if err := loaded.Load(); nil != err {
+		t.Fatal(err)
+	}
+	if 3 != loaded.CountCards() || nil == loaded.GetCard(savedID) || nil == loaded.GetCard(addedID) {
+		t.Fatalf("cards count [%d] != [3]", loaded.CountCards())
+	}
+	if 1 != loaded.GetCard(reviewedID).GetReps() {
+		t.Fatalf("reviewed card reps [%d] != [1]", loaded.GetCard(reviewedID).GetReps())
+	}
+
+	// 没有变更时保存会移除最新备份对应的日志
+	if err := loaded.Save(); nil != err {
+		t.Fatal(err)
+	}
+	if err := loaded.Save(); nil != err {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(getBackupPath(loaded.getJournalPath(), 1)); !os.IsNotExist(err) {
+		t.Fatal("journal backup should be removed after saving without changes")
+	}
+}
+
+func TestAppendRecordsAfterOpenFailure(t *testing.T) {
+	const saveDir = "testdata"
+	os.MkdirAll(saveDir, 0755)
+	defer os.RemoveAll(saveDir)
+
+	// 第一次打开失败（路径是文件夹），之后的追加和读取不能被文件锁阻塞
+	p := getJournalPath(saveDir, "test-open-failure")
+	os.MkdirAll(p, 0755)
+	if err := appendRecords(p, &journalEntry{Op: journalAdd, ID: newID()}); nil == err {
+		t.Fatal("append records to a directory should fail")
+	}
+	os.RemoveAll(p)
+
+	done := make(chan error)
+	go func() {
+		if err := appendRecords(p, &journalEntry{Op: journalAdd, ID: newID()}); nil != err {
+			done <- err
+			return
+		}
+		done <- readJournal(p, func(entry *journalEntry) bool { return true })
+	}()
+	select {
+	case err := <-done:
+		if nil != err {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("append records blocked after a failed open")
+	}
+}
@@ -0,0 +1,88 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"sort"
+
+	"github.com/siyuan-note/logging"
+)
+
+// LeechTag 是标记难记闪卡时添加的标签。
+const LeechTag = "leech"
+
+// Leech 描述了难记闪卡及其遗忘记录。
+type Leech struct {
+	Card   Card   // 闪卡
+	Lapses []*Log // 遗忘时（复习状态下评分为 Again）的复习日志，按复习时间先后排序
+}
+
+// markLeech 在复习 log 之后检查闪卡 card 的遗忘次数，达到阈值时标记为难记闪卡，调用方需要持有 store.lock。
+func (store *BaseStore) markLeech(card Card, log *Log) {
+	if 1 > store.leech.Threshold || Again != log.Rating || card.IsLeech() || store.leech.Threshold > card.GetLapses() {
+		return
+	}
+
+	card.SetLeech(true)
+	card.SetTags(append(card.GetTags(), LeechTag))
+	store.reindexTags(card)
+	if store.leech.Suspend {
+		card.SetSuspended(true)
+	}
+	logging.LogInfof("marked card [%s] as leech with [%d] lapses", card.ID(), card.GetLapses())
+}
+
+// UnmarkLeech 取消 cardID 闪卡的难记闪卡标记并移除难记闪卡标签，自动暂停的闪卡需要使用 Unsuspend 取消暂停。
+func (deck *Deck) UnmarkLeech(cardID string) {
+	deck.RemoveTags(cardID, LeechTag)
+}
+
+// Leeches 返回闪卡包中的难记闪卡及其遗忘记录，按遗忘次数从多到少排序。
+func (deck *Deck) Leeches() (ret []*Leech, err error) {
+	deck.lock.Lock()
+	leeches := map[string]*Leech{}
+	var cardIDs []string
+	for _, card := range deck.store.GetCardsByBlockIDs(deck.store.GetBlockIDs()) {
+		if card.IsLeech() {
+			leech := &Leech{Card: card}
+			ret = append(ret, leech)
+			leeches[card.ID()] = leech
+			cardIDs = append(cardIDs, card.ID())
+		}
+	}
+	deck.lock.Unlock()
+	if 1 > len(ret) {
+		return
+	}
+
+	query := &LogQuery{CardIDs: cardIDs, Ratings: []Rating{Again}, States: []State{Review}}
+	if err = deck.Logs(query, func(log *Log) bool {
+		leech := leeches[log.CardID]
+		leech.Lapses = append(leech.Lapses, log)
+		return true
+	}); nil != err {
+		return
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool {
+		if ret[i].Card.GetLapses() != ret[j].Card.GetLapses() {
+			return ret[i].Card.GetLapses() > ret[j].Card.GetLapses()
+		}
+		return ret[i].Card.ID() < ret[j].Card.ID()
+	})
+	return
+}
@@ -0,0 +1,140 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLeeches(t *testing.T) {
+	const saveDir = "testdata"
+	os.MkdirAll(saveDir, 0755)
+	defer os.RemoveAll(saveDir)
+
+	opts := DefaultDeckOptions()
+	opts.Leech = LeechOptions{Threshold: 2, Suspend: true}
+	clock := NewManualClock(time.Date(2023, 5, 20, 8, 0, 0, 0, time.Local))
+	deck, err := LoadDeckWithClock(saveDir, newID(), opts, clock)
+	if nil != err {
+		t.Fatal(err)
+	}
+	leechID, otherID := newID(), newID()
+	deck.AddCard(leechID, newID())
+	deck.AddCard(otherID, newID())
+
+	review := func(cardID string, rating Rating) {
+		if card := deck.GetCard(cardID); clock.Now().Before(card.GetDue()) {
+			clock.Set(card.GetDue())
+		}
+		if err := deck.SaveLog(deck.Review(cardID, rating)); nil != err {
+			t.Fatal(err)
+		}
+	}
+	review(otherID, Easy)
+	review(leechID, Easy)
+	for i := 0; i < 2; i++ {
+		if deck.GetCard(leechID).IsLeech() {
+			t.Fatalf("card marked as leech after [%d] lapses", i)
+		}
+		review(leechID, Again)
+		review(leechID, Good)
+	}
+
+	card := deck.GetCard(leechID)
+	if !card.IsLeech() || !card.IsSuspended() || !card.HasTag(LeechTag) || 2 != card.GetLapses() {
+		t.Fatalf("card [leech=%v, suspended=%v, lapses=%d] not handled as leech", card.IsLeech(), card.IsSuspended(), card.GetLapses())
+	}
+	// 不重新加载也能按标签查询到刚刚标记的难记闪卡
+	if cards := deck.CardsByTag(LeechTag); 1 != len(cards) || leechID != cards[0].ID() {
+		t.Fatalf("leech tag cards len [%d] != [1]", len(cards))
+	}
+	leeches, err := deck.Leeches()
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 1 != len(leeches) || leechID != leeches[0].Card.ID() || 2 != len(leeches[0].Lapses) {
+		t.Fatalf("leeches mismatched")
+	}
+	if leeches[0].Lapses[0].Reviewed >= leeches[0].Lapses[1].Reviewed {
+		t.Fatal("lapses not ordered by reviewed time")
+	}
+
+	// 撤销标记难记闪卡的复习之后，难记闪卡的标记、标签和自动暂停也被撤销
+	for i := 0; i < 2; i++ {
+		if _, err = deck.UndoReview(); nil != err {
+			t.Fatal(err)
+		}
+	}
+	card = deck.GetCard(leechID)
+	if card.IsLeech() || card.IsSuspended() || card.HasTag(LeechTag) || 1 != card.GetLapses() {
+		t.Fatalf("card [leech=%v, suspended=%v, lapses=%d] still handled as leech", card.IsLeech(), card.IsSuspended(), card.GetLapses())
+	}
+	if cards := deck.CardsByTag(LeechTag); 0 != len(cards) {
+		t.Fatalf("leech tag cards len [%d] != [0]", len(cards))
+	}
+}
+
+func TestSetLeechOptions(t *testing.T) {
+	const saveDir = "testdata"
+	os.MkdirAll(saveDir, 0755)
+	defer os.RemoveAll(saveDir)
+
+	clock := NewManualClock(time.Date(2023, 5, 20, 8, 0, 0, 0, time.Local))
+	deck, err := LoadDeckWithClock(saveDir, newID(), nil, clock)
+	if nil != err {
+		t.Fatal(err)
+	}
+	cardID := newID()
+	deck.AddCard(cardID, newID())
+
+	opts := DefaultDeckOptions()
+	opts.Leech.Threshold = -1
+	if err = deck.SetOptions(opts); nil == err {
+		t.Fatal("set invalid options should fail")
+	}
+
+	// 加载之后修改的选项在复习时生效
+	opts.Leech = LeechOptions{Threshold: 1, Suspend: true}
+	if err = deck.SetOptions(opts); nil != err {
+		t.Fatal(err)
+	}
+	deck.Review(cardID, Easy)
+	clock.Set(deck.GetCard(cardID).GetDue())
+	deck.Review(cardID, Again)
+	if card := deck.GetCard(cardID); !card.IsLeech() || !card.IsSuspended() {
+		t.Fatalf("card [lapses=%d] not handled as leech", card.GetLapses())
+	}
+	if 1 != deck.Options.Leech.Threshold {
+		t.Fatalf("deck leech threshold [%d] != [1]", deck.Options.Leech.Threshold)
+	}
+
+	// 取消难记闪卡标记，移除难记闪卡标签也会取消标记
+	deck.UnmarkLeech(cardID)
+	if card := deck.GetCard(cardID); card.IsLeech() || card.HasTag(LeechTag) || !card.IsSuspended() {
+		t.Fatal("card leech not unmarked")
+	}
+	deck.updateCard(cardID, func(card Card) {
+		card.SetLeech(true)
+		card.SetTags([]string{LeechTag})
+	})
+	deck.RemoveTags(cardID, LeechTag)
+	if card := deck.GetCard(cardID); card.IsLeech() || 0 != len(deck.CardsByTag(LeechTag)) {
+		t.Fatal("card leech not unmarked after removing leech tag")
+	}
+}
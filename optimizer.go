@@ -0,0 +1,313 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/open-spaced-repetition/go-fsrs/v3"
+	"github.com/siyuan-note/logging"
+)
+
+// ErrNotEnoughLogs 描述了复习日志不足以拟合参数的错误。
+var ErrNotEnoughLogs = errors.New("not enough review logs")
+
+// OptimizerOptions 描述了 FSRS 算法参数拟合的选项，零值字段使用默认值。
+type OptimizerOptions struct {
+	Weights      string  // 初始参数，以逗号分隔，为空时使用 FSRS 默认参数
+	Epochs       int     // 训练轮数，默认 5
+	BatchSize    int     // 每批包含的闪卡数量，默认 512
+	LearningRate float64 // 学习率，默认 0.04
+	MinReviews   int     // 参与拟合的最少复习次数，默认 64
+	Seed         int64   // 打乱闪卡顺序的随机种子
+
+	DeckIDs []string // 只使用这些闪卡包的复习日志，为空时使用所有闪卡包的复习日志
+}
+
+// optimizerWeightBounds 为每个 FSRS 参数的取值范围。
+var optimizerWeightBounds = [len(fsrs.Weights{})][2]float64{
+	{0.001, 100}, {0.001, 100}, {0.001, 100}, {0.001, 100},
+	{1, 10}, {0.001, 4}, {0.001, 4}, {0.001, 0.75},
+	{0, 4.5}, {0, 0.8}, {0.001, 3.5}, {0.001, 5},
+	{0.001, 0.25}, {0.001, 0.9}, {0, 4}, {0, 1},
+	{1, 6}, {0, 2}, {0, 2},
+}
+
+// OptimizeWeights 读取数据文件夹 saveDir 下的复习日志，使用梯度下降拟合 FSRS 算法参数，返回以逗号分隔的参数。
+//
+// 拟合的参数用于某个闪卡包时，应该通过 opts.DeckIDs 指定该闪卡包，只使用它自己的复习日志。
+func OptimizeWeights(saveDir string, opts *OptimizerOptions) (weights string, err error) {
+	if nil == opts {
+		opts = &OptimizerOptions{}
+	}
+
+	w := fsrs.DefaultWeights()
+	if "" != strings.TrimSpace(opts.Weights) {
+		var initWeights []float64
+		if initWeights, err = ParseWeights(opts.Weights); nil != err {
+			return
+		}
+		w = toFSRSWeights(initWeights)
+	}
+
+	var logs []*Log
+	if err = NewLogReader(saveDir).Query(&LogQuery{DeckIDs: opts.DeckIDs}, func(log *Log) bool {
+		logs = append(logs, log)
+		return true
+	}); nil != err {
+		return
+	}
+
+	o := newFSRSOptimizer(opts)
+	histories := buildReviewHistories(logs)
+	if o.minReviews > countPredictions(histories) {
+		err = ErrNotEnoughLogs
+		return
+	}
+
+	w = o.train(histories, w)
+	weights = formatWeights(w)
+	return
+}
+
+// reviewHistory 描述了一张闪卡从新卡开始按时间排序的复习记录。
+type reviewHistory []*optimizerReview
+
+type optimizerReview struct {
+	rating      Rating
+	state       State   // 复习前的状态
+	elapsedDays float64 // 距离上次复习的天数
+}
+
+// buildReviewHistories 将复习日志按闪卡分组并按复习时间排序，只保留从新卡开始的完整记录。
+func buildReviewHistories(logs []*Log) (ret []reviewHistory) {
+	cardLogs := map[string][]*Log{}
+	for _, log := range logs {
+		if Again > log.Rating || Easy < log.Rating {
+			continue
+		}
+		cardLogs[log.CardID] = append(cardLogs[log.CardID], log)
+	}
+
+	var cardIDs []string
+	for cardID := range cardLogs {
+		cardIDs = append(cardIDs, cardID)
+	}
+	sort.Strings(cardIDs)
+
+	for _, cardID := range cardIDs {
+		logs := cardLogs[cardID]
+		sort.SliceStable(logs, func(i, j int) bool { return logs[i].Reviewed < logs[j].Reviewed })
+
+		var history reviewHistory
+		for _, log := range logs {
+			if New == log.State {
+				// 闪卡被重置为新卡后重新开始一段记录
+				if 1 < len(history) {
+					ret = append(ret, history)
+				}
+				history = reviewHistory{}
+			}
+			if nil == history {
+				continue
+			}
+			history = append(history, &optimizerReview{rating: log.Rating, state: log.State, elapsedDays: float64(log.ElapsedDays)})
+		}
+		if 1 < len(history) {
+			ret = append(ret, history)
+		}
+	}
+	return
+}
+
+// countPredictions 返回可用于计算损失的复习次数，即复习前处于复习状态的次数。
+func countPredictions(histories []reviewHistory) (ret int) {
+	for _, history := range histories {
+		for _, review := range history {
+			if Review == review.state {
+				ret++
+			}
+		}
+	}
+	return
+}
+
+type fsrsOptimizer struct {
+	epochs       int
+	batchSize    int
+	learningRate float64
+	minReviews   int
+	random       *rand.Rand
+	decay        float64
+	factor       float64
+}
+
+func newFSRSOptimizer(opts *OptimizerOptions) *fsrsOptimizer {
+	params := fsrs.DefaultParam()
+	ret := &fsrsOptimizer{
+		epochs:       opts.Epochs,
+		batchSize:    opts.BatchSize,
+		learningRate: opts.LearningRate,
+		minReviews:   opts.MinReviews,
+		random:       rand.New(rand.NewSource(opts.Seed)),
+		decay:        params.Decay,
+		factor:       params.Factor,
+	}
+	if 1 > ret.epochs {
+		ret.epochs = 5
+	}
+	if 1 > ret.batchSize {
+		ret.batchSize = 512
+	}
+	if 0 >= ret.learningRate {
+		ret.learningRate = 0.04
+	}
+	if 1 > ret.minReviews {
+		ret.minReviews = 64
+	}
+	return ret
+}
+
+// train 使用 Adam 优化器按批次最小化回忆预测的交叉熵损失，如果拟合后的损失反而变大则返回初始参数。
+func (o *fsrsOptimizer) train(histories []reviewHistory, init fsrs.Weights) fsrs.Weights {
+	const (
+		beta1   = 0.9
+		beta2   = 0.999
+		epsilon = 1e-8
+	)
+
+	w := init
+	clampWeights(&w)
+	var m, v fsrs.Weights
+	step := 0
+	shuffled := make([]reviewHistory, len(histories))
+	copy(shuffled, histories)
+	for epoch := 0; epoch < o.epochs; epoch++ {
+		o.random.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		for start := 0; start < len(shuffled); start += o.batchSize {
+			end := min(start+o.batchSize, len(shuffled))
+			grad, ok := o.gradient(shuffled[start:end], w)
+			if !ok {
+				continue
+			}
+
+			step++
+			for i := range w {
+				m[i] = beta1*m[i] + (1-beta1)*grad[i]
+				v[i] = beta2*v[i] + (1-beta2)*grad[i]*grad[i]
+				mHat := m[i] / (1 - math.Pow(beta1, float64(step)))
+				vHat := v[i] / (1 - math.Pow(beta2, float64(step)))
+				w[i] -= o.learningRate * mHat / (math.Sqrt(vHat) + epsilon)
+			}
+			clampWeights(&w)
+		}
+	}
+
+	initLoss, _ := o.loss(histories, init)
+	loss, _ := o.loss(histories, w)
+	logging.LogInfof("optimized fsrs weights [loss=%.6f -> %.6f]", initLoss, loss)
+	if loss > initLoss {
+		return init
+	}
+	return w
+}
+
+// gradient 使用中心差分计算平均损失对每个参数的梯度。
+func (o *fsrsOptimizer) gradient(histories []reviewHistory, w fsrs.Weights) (ret fsrs.Weights, ok bool) {
+	const h = 1e-4
+
+	if _, n := o.loss(histories, w); 0 == n {
+		return
+	}
+
+	for i := range w {
+		plus, minus := w, w
+		plus[i] += h
+		minus[i] -= h
+		lossPlus, _ := o.loss(histories, plus)
+		lossMinus, _ := o.loss(histories, minus)
+		ret[i] = (lossPlus - lossMinus) / (2 * h)
+	}
+	ok = true
+	return
+}
+
+// loss 返回参数 w 下回忆预测的平均交叉熵损失以及参与计算的复习次数。
+func (o *fsrsOptimizer) loss(histories []reviewHistory, w fsrs.Weights) (ret float64, n int) {
+	for _, history := range histories {
+		var stability, difficulty float64
+		for _, review := range history {
+			switch review.state {
+			case New:
+				stability = math.Max(w[review.rating-1], 0.1)
+				difficulty = initDifficulty(&w, review.rating)
+			case Learning, Relearning:
+				stability = stability * math.Exp(w[17]*(float64(review.rating-Good)+w[18]))
+				difficulty = nextDifficulty(&w, difficulty, review.rating)
+			case Review:
+				retrievability := math.Pow(1+o.factor*review.elapsedDays/stability, o.decay)
+				p := math.Min(math.Max(retrievability, 1e-6), 1-1e-6)
+				if Again < review.rating {
+					ret -= math.Log(p)
+				} else {
+					ret -= math.Log(1 - p)
+				}
+				n++
+
+				if Again == review.rating {
+					forget := w[11] * math.Pow(difficulty, -w[12]) * (math.Pow(stability+1, w[13]) - 1) * math.Exp((1-retrievability)*w[14])
+					stability = math.Min(stability/math.Exp(w[17]*w[18]), forget)
+				} else {
+					hardPenalty, easyBonus := 1.0, 1.0
+					if Hard == review.rating {
+						hardPenalty = w[15]
+					} else if Easy == review.rating {
+						easyBonus = w[16]
+					}
+					stability = stability * (1 + math.Exp(w[8])*(11-difficulty)*math.Pow(stability, -w[9])*(math.Exp((1-retrievability)*w[10])-1)*hardPenalty*easyBonus)
+				}
+				difficulty = nextDifficulty(&w, difficulty, review.rating)
+			}
+			stability = math.Min(math.Max(stability, 0.01), 36500)
+		}
+	}
+	if 0 < n {
+		ret /= float64(n)
+	}
+	return
+}
+
+func initDifficulty(w *fsrs.Weights, rating Rating) float64 {
+	return math.Min(math.Max(w[4]-math.Exp(w[5]*float64(rating-1))+1, 1), 10)
+}
+
+func nextDifficulty(w *fsrs.Weights, difficulty float64, rating Rating) float64 {
+	deltaD := -w[6] * float64(rating-Good)
+	next := difficulty + (10-difficulty)*deltaD/9
+	next = w[7]*initDifficulty(w, Easy) + (1-w[7])*next
+	return math.Min(math.Max(next, 1), 10)
+}
+
+func clampWeights(w *fsrs.Weights) {
+	for i := range w {
+		w[i] = math.Min(math.Max(w[i], optimizerWeightBounds[i][0]), optimizerWeightBounds[i][1])
+	}
+}
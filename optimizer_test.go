@@ -0,0 +1,112 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/open-spaced-repetition/go-fsrs/v3"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestOptimizeWeights(t *testing.T) {
+	const saveDir = "testdata"
+	os.MkdirAll(filepath.Join(saveDir, "logs"), 0755)
+	defer os.RemoveAll(saveDir)
+
+	if _, err := OptimizeWeights(saveDir, nil); ErrNotEnoughLogs != err {
+		t.Fatalf("optimize without logs [err=%v]", err)
+	}
+
+	// 使用默认参数模拟复习，评分按当时的可提取性随机产生，初始参数在默认参数的基础上故意偏离
+	truthWeights := fsrs.DefaultWeights()
+	initWeights := truthWeights
+	initWeights[2] *= 0.3
+	initWeights[8] *= 0.5
+	formatWeights := func(w fsrs.Weights) string {
+		var ret []string
+		for _, v := range w {
+			ret = append(ret, strconv.FormatFloat(v, 'f', -1, 64))
+		}
+		return strings.Join(ret, ",")
+	}
+	truth := NewFSRSScheduler(requestRetention, maximumInterval, formatWeights(truthWeights))
+	random := rand.New(rand.NewSource(1))
+	start := time.Now().AddDate(-1, 0, 0)
+	var logs []*Log
+	for i := 0; i < 300; i++ {
+		card := truth.NewCard(newID(), newID())
+		now := start
+		rating := Good
+		for j := 0; j < 8; j++ {
+			logs = append(logs, truth.Repeat(card, now, rating))
+			now = card.GetDue().Add(time.Duration(random.Intn(48)) * time.Hour)
+			rating = Good
+			if random.Float64() > truth.Retrievability(card, now) {
+				rating = Again
+			}
+		}
+	}
+	data, err := msgpack.Marshal(logs)
+	if nil != err {
+		t.Fatal(err)
+	}
+	deckID := newID()
+	os.MkdirAll(getDeckLogsDir(saveDir, deckID), 0755)
+	if err = os.WriteFile(filepath.Join(getDeckLogsDir(saveDir, deckID), start.Format("200601")+".msgpack"), data, 0644); nil != err {
+		t.Fatal(err)
+	}
+
+	// 只使用指定闪卡包的复习日志
+	if _, err = OptimizeWeights(saveDir, &OptimizerOptions{DeckIDs: []string{newID()}}); ErrNotEnoughLogs != err {
+		t.Fatalf("optimize other deck [err=%v]", err)
+	}
+
+	optimized, err := OptimizeWeights(saveDir, &OptimizerOptions{Weights: formatWeights(initWeights), Epochs: 3, DeckIDs: []string{deckID}})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 19 != len(strings.Split(optimized, ",")) {
+		t.Fatalf("optimized weights [%s]", optimized)
+	}
+	t.Logf("optimized weights [%s]", optimized)
+
+	o := newFSRSOptimizer(&OptimizerOptions{})
+	histories := buildReviewHistories(logs)
+	optimizedWeights, err := ParseWeights(optimized)
+	if nil != err {
+		t.Fatal(err)
+	}
+	fitted := toFSRSWeights(optimizedWeights)
+	initLoss, _ := o.loss(histories, initWeights)
+	loss, n := o.loss(histories, fitted)
+	if 0 == n || loss >= initLoss {
+		t.Fatalf("loss [%f] >= init loss [%f]", loss, initLoss)
+	}
+	for _, i := range []int{2, 8} {
+		if math.Abs(fitted[i]-truthWeights[i]) >= math.Abs(initWeights[i]-truthWeights[i]) {
+			t.Fatalf("weight [w%d=%f] not moved from [%f] toward [%f]", i, fitted[i], initWeights[i], truthWeights[i])
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/open-spaced-repetition/go-fsrs/v3"
+)
+
+const (
+	maxMaximumInterval    = 36500 // 最大复习间隔天数的上限
+	defaultBackups        = 3     // 默认的备份份数
+	defaultLeechThreshold = 8     // 默认的难记闪卡遗忘次数阈值
+	maxBackups            = 100   // 备份份数的上限
+)
+
+// DeckOptions 描述了闪卡包选项，选项随闪卡包一起保存。
+type DeckOptions struct {
+	Algo Algo       // 间隔重复算法，仅用于新建闪卡包，已有闪卡包使用其保存的算法
+	FSRS FSRSParams // FSRS 算法参数
+	SM2  SM2Params  // SM-2 算法参数
+
+	Backups int // 保存时轮转保留的备份份数，为 0 时使用默认值 3，小于 0 时不备份
+
+	Leech LeechOptions // 难记闪卡处理选项
+
+	Order       DueOrder // 到期闪卡的排序策略，为空时按到期时间排序
+	ShuffleSeed int64    // 打乱顺序使用的随机数种子，为 0 时在加载闪卡包时生成并随闪卡包保存
+}
+
+// LeechOptions 描述了难记闪卡（遗忘次数过多的闪卡）的处理选项。
+type LeechOptions struct {
+	Threshold int  // 遗忘次数达到该值时标记为难记闪卡，为 0 时不检测
+	Suspend   bool // 标记为难记闪卡时是否同时暂停
+}
+
+// FSRSParams 描述了 FSRS 算法参数。
+type FSRSParams struct {
+	RequestRetention float64   // 期望保留率，取值范围 (0, 1)
+	MaximumInterval  int       // 最大复习间隔天数，取值范围 [1, 36500]
+	Weights          []float64 // 算法参数，个数为 17 或 19，为空时使用默认参数
+}
+
+// SM2Params 描述了 SM-2 算法参数。
+type SM2Params struct {
+	MaximumInterval int // 最大复习间隔天数，取值范围 [1, 36500]
+}
+
+// DefaultDeckOptions 返回默认的闪卡包选项。
+func DefaultDeckOptions() *DeckOptions {
+	return &DeckOptions{
+		Algo: AlgoFSRS,
+		FSRS: FSRSParams{
+			RequestRetention: 0.9,
+			MaximumInterval:  maxMaximumInterval,
+		},
+		SM2: SM2Params{
+			MaximumInterval: maxMaximumInterval,
+		},
+		Leech: LeechOptions{
+			Threshold: defaultLeechThreshold,
+		},
+	}
+}
+
+// Validate 校验闪卡包选项。
+func (opts *DeckOptions) Validate() (err error) {
+	if "" == opts.Algo {
+		return fmt.Errorf("algo is empty")
+	}
+	if maxBackups < opts.Backups {
+		return fmt.Errorf("invalid backups [%d], it must be less than or equal to %d", opts.Backups, maxBackups)
+	}
+	if 0 > opts.Leech.Threshold {
+		return fmt.Errorf("invalid leech threshold [%d], it must be greater than or equal to 0", opts.Leech.Threshold)
+	}
+	if !opts.Order.valid() {
+		return fmt.Errorf("invalid due order [%s]", opts.Order)
+	}
+	if err = opts.FSRS.Validate(); nil != err {
+		return
+	}
+	return opts.SM2.Validate()
+}
+
+func (opts *DeckOptions) backups() int {
+	if 0 == opts.Backups {
+		return defaultBackups
+	}
+	if 0 > opts.Backups {
+		return 0
+	}
+	return opts.Backups
+}
+
+func (opts *DeckOptions) clone() *DeckOptions {
+	ret := *opts
+	ret.FSRS.Weights = append([]float64(nil), opts.FSRS.Weights...)
+	return &ret
+}
+
+// Validate 校验 FSRS 算法参数。
+func (params *FSRSParams) Validate() error {
+	if math.IsNaN(params.RequestRetention) || 0 >= params.RequestRetention || 1 <= params.RequestRetention {
+		return fmt.Errorf("invalid request retention [%v], it must be in (0, 1)", params.RequestRetention)
+	}
+	if err := validateMaximumInterval(params.MaximumInterval); nil != err {
+		return err
+	}
+	if 0 != len(params.Weights) && 17 != len(params.Weights) && len(params.Weights) != len(fsrs.Weights{}) {
+		return fmt.Errorf("invalid weights count [%d], it must be 17 or 19", len(params.Weights))
+	}
+	for i, w := range params.Weights {
+		if math.IsNaN(w) || math.IsInf(w, 0) || 0 > w {
+			return fmt.Errorf("invalid weight [w%d=%v]", i, w)
+		}
+	}
+	return nil
+}
+
+// Validate 校验 SM-2 算法参数。
+func (params *SM2Params) Validate() error {
+	return validateMaximumInterval(params.MaximumInterval)
+}
+
+func validateMaximumInterval(maximumInterval int) error {
+	if 1 > maximumInterval || maxMaximumInterval < maximumInterval {
+		return fmt.Errorf("invalid maximum interval [%d], it must be in [1, %d]", maximumInterval, maxMaximumInterval)
+	}
+	return nil
+}
+
+// ParseWeights 解析以逗号分隔的 FSRS 算法参数，空字符串返回 nil 表示使用默认参数。
+func ParseWeights(weights string) (ret []float64, err error) {
+	weights = strings.TrimSpace(weights)
+	if "" == weights {
+		return
+	}
+
+	for i, w := range strings.Split(weights, ",") {
+		var f float64
+		if f, err = strconv.ParseFloat(strings.TrimSpace(w), 64); nil != err {
+			err = fmt.Errorf("parse weight [w%d=%s] failed: %s", i, strings.TrimSpace(w), err)
+			return
+		}
+		ret = append(ret, f)
+	}
+	return
+}
@@ -0,0 +1,147 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"os"
+	"testing"
+
+	"github.com/open-spaced-repetition/go-fsrs/v3"
+)
+
+func TestDeckOptionsValidate(t *testing.T) {
+	if err := DefaultDeckOptions().Validate(); nil != err {
+		t.Fatal(err)
+	}
+
+	invalids := []func(opts *DeckOptions){
+		func(opts *DeckOptions) { opts.Algo = "" },
+		func(opts *DeckOptions) { opts.FSRS.RequestRetention = 0 },
+		func(opts *DeckOptions) { opts.FSRS.RequestRetention = 1 },
+		func(opts *DeckOptions) { opts.FSRS.MaximumInterval = 0 },
+		func(opts *DeckOptions) { opts.FSRS.MaximumInterval = maxMaximumInterval + 1 },
+		func(opts *DeckOptions) { opts.FSRS.Weights = make([]float64, 20) },
+		func(opts *DeckOptions) { opts.FSRS.Weights = make([]float64, 5) },
+		func(opts *DeckOptions) { opts.FSRS.Weights = append(make([]float64, 16), -1) },
+		func(opts *DeckOptions) { opts.SM2.MaximumInterval = -1 },
+	}
+	for i, invalid := range invalids {
+		opts := DefaultDeckOptions()
+		invalid(opts)
+		if nil == opts.Validate() {
+			t.Fatalf("invalid options [%d] passed validation", i)
+		}
+	}
+
+	if _, err := ParseWeights("0.4, x"); nil == err {
+		t.Fatal("parse invalid weights should fail")
+	}
+	ws, err := ParseWeights(weights)
+	if nil != err || 17 != len(ws) {
+		t.Fatalf("parse weights [len=%d, err=%v]", len(ws), err)
+	}
+
+	// 17 个参数时 w17 和 w18 使用 go-fsrs 的默认参数
+	defaults := fsrs.DefaultParam().W
+	if w := toFSRSWeights(ws); ws[16] != w[16] || defaults[17] != w[17] || defaults[18] != w[18] {
+		t.Fatalf("fsrs weights %v not padded with defaults", w)
+	}
+}
+
+func TestDeckOptionsPersisted(t *testing.T) {
+	const saveDir = "testdata"
+	os.MkdirAll(saveDir, 0755)
+	defer os.RemoveAll(saveDir)
+
+	opts := DefaultDeckOptions()
+	opts.FSRS.RequestRetention = 0.85
+	opts.FSRS.MaximumInterval = 365
+	opts.FSRS.Weights, _ = ParseWeights(weights)
+	deckID := newID()
+	deck, err := LoadDeckWithOptions(saveDir, deckID, opts)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if err = deck.Save(); nil != err {
+		t.Fatal(err)
+	}
+
+	deck, err = LoadDeckWithOptions(saveDir, deckID, nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 0.85 != deck.Options.FSRS.RequestRetention || 365 != deck.Options.FSRS.MaximumInterval || 17 != len(deck.Options.FSRS.Weights) {
+		t.Fatalf("deck options not persisted [%+v]", deck.Options.FSRS)
+	}
+
+	// 修改算法参数后重建调度算法
+	opts.FSRS.MaximumInterval = 1
+	if err = deck.SetOptions(opts); nil != err {
+		t.Fatal(err)
+	}
+	cardID := newID()
+	deck.AddCard(cardID, newID())
+	deck.Review(cardID, Easy)
+	if due := deck.GetCard(cardID).GetDue(); due.After(deck.Now().AddDate(0, 0, 1)) {
+		t.Fatalf("card due [%s] exceeds maximum interval", due)
+	}
+
+	opts.FSRS.RequestRetention = 2
+	if _, err = LoadDeckWithOptions(saveDir, deckID, opts); nil == err {
+		t.Fatal("load deck with invalid options should fail")
+	}
+}
+
+func TestLoadDeckKeepsSavedOptions(t *testing.T) {
+	const saveDir = "testdata"
+	os.MkdirAll(saveDir, 0755)
+	defer os.RemoveAll(saveDir)
+
+	opts := DefaultDeckOptions()
+	opts.Backups = 5
+	opts.Leech = LeechOptions{Threshold: 3, Suspend: true}
+	opts.Order = DueOrderShuffle
+	deckID := newID()
+	deck, err := LoadDeckWithOptions(saveDir, deckID, opts)
+	if nil != err {
+		t.Fatal(err)
+	}
+	seed := deck.Options.ShuffleSeed
+	if err = deck.Save(); nil != err {
+		t.Fatal(err)
+	}
+
+	// 旧的加载方式只覆盖传入的参数
+	deck, err = LoadDeck(saveDir, deckID, 0.8, 180, "")
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 0.8 != deck.Options.FSRS.RequestRetention || 180 != deck.Options.FSRS.MaximumInterval {
+		t.Fatalf("deck options not overridden [%+v]", deck.Options.FSRS)
+	}
+	if 5 != deck.Options.Backups || opts.Leech != deck.Options.Leech || DueOrderShuffle != deck.Options.Order || seed != deck.Options.ShuffleSeed {
+		t.Fatalf("saved deck options lost [%+v]", deck.Options)
+	}
+
+	// 参数无效时和 NewFSRSScheduler 一样使用默认参数
+	if deck, err = LoadDeck(saveDir, deckID, 0.8, 180, "0.4, x"); nil != err {
+		t.Fatal(err)
+	}
+	if defaults := DefaultDeckOptions().FSRS; defaults.RequestRetention != deck.Options.FSRS.RequestRetention || 0 != len(deck.Options.FSRS.Weights) {
+		t.Fatalf("deck options [%+v] not defaults", deck.Options.FSRS)
+	}
+}
@@ -0,0 +1,146 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"sort"
+	"time"
+)
+
+// DueOrder 描述了到期闪卡的排序策略，排序结果是确定的，同样的闪卡多次查询得到同样的顺序。
+type DueOrder string
+
+const (
+	DueOrderDue            DueOrder = "due"            // 按到期时间先后排序，默认策略
+	DueOrderRetrievability DueOrder = "retrievability" // 可提取性（回忆概率）低的在前，新卡在最后
+	DueOrderOverdueness    DueOrder = "overdueness"    // 相对逾期程度（逾期天数与复习间隔之比）高的在前，新卡在最后
+	DueOrderDifficulty     DueOrder = "difficulty"     // 难度高的在前，新卡在最后
+	DueOrderBlock          DueOrder = "block"          // 同一内容块的闪卡排在一起，内容块之间按其中最早的到期时间排序
+	DueOrderShuffle        DueOrder = "shuffle"        // 按随机数种子打乱，种子不变时顺序不变
+)
+
+// valid 判断排序策略是否有效，空字符串表示使用默认策略。
+func (order DueOrder) valid() bool {
+	switch order {
+	case "", DueOrderDue, DueOrderRetrievability, DueOrderOverdueness, DueOrderDifficulty, DueOrderBlock, DueOrderShuffle:
+		return true
+	}
+	return false
+}
+
+// orderKey 描述了闪卡排序时使用的键，在排序前计算一次。
+type orderKey struct {
+	card  Card
+	due   time.Time
+	value float64 // 按策略计算的排序值，越小越靠前
+	group time.Time
+}
+
+// sortDues 按查询选项 opts 指定的排序策略对到期闪卡 cards 排序，opts 没有指定时使用闪卡包的排序策略，调用方需要持有 store.lock。
+func (store *BaseStore) sortDues(cards []Card, opts *QueryOptions, now time.Time) {
+	order, seed := store.order, store.seed
+	if nil != opts {
+		if "" != opts.Order {
+			order = opts.Order
+		}
+		if 0 != opts.Seed {
+			seed = opts.Seed
+		}
+	}
+	sortCards(cards, order, seed, now, func(card Card) float64 {
+		return store.scheduler.Retrievability(card, now)
+	})
+}
+
+// sortCards 按排序策略 order 对闪卡 cards 排序，seed 为打乱顺序使用的随机数种子，retrievability 返回已复习过的闪卡在 now 时的可提取性。
+//
+// 排序值相同时按到期时间先后、再按闪卡 ID 排序。
+func sortCards(cards []Card, order DueOrder, seed int64, now time.Time, retrievability func(card Card) float64) {
+	keys := make([]*orderKey, len(cards))
+	groups := map[string]time.Time{}
+	for i, card := range cards {
+		key := &orderKey{card: card, due: card.GetDue()}
+		reviewed := !card.GetLastReview().IsZero()
+		switch order {
+		case DueOrderRetrievability:
+			key.value = 1
+			if reviewed {
+				key.value = retrievability(card)
+			}
+		case DueOrderOverdueness:
+			key.value = 1
+			if reviewed {
+				key.value = -overdueness(card, now)
+			}
+		case DueOrderDifficulty:
+			key.value = 1
+			if reviewed {
+				key.value = -card.GetCardState().Difficulty
+			}
+		case DueOrderBlock:
+			if due, ok := groups[card.BlockID()]; !ok || key.due.Before(due) {
+				groups[card.BlockID()] = key.due
+			}
+		case DueOrderShuffle:
+			key.value = shuffleValue(seed, card.ID())
+		}
+		keys[i] = key
+	}
+	if DueOrderBlock == order {
+		for _, key := range keys {
+			key.group = groups[key.card.BlockID()]
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if DueOrderBlock == order {
+			if !a.group.Equal(b.group) {
+				return a.group.Before(b.group)
+			}
+			if a.card.BlockID() != b.card.BlockID() {
+				return a.card.BlockID() < b.card.BlockID()
+			}
+		}
+		if a.value != b.value {
+			return a.value < b.value
+		}
+		if !a.due.Equal(b.due) {
+			return a.due.Before(b.due)
+		}
+		return a.card.ID() < b.card.ID()
+	})
+	for i, key := range keys {
+		cards[i] = key.card
+	}
+}
+
+// overdueness 返回闪卡 card 在 now 时的相对逾期程度，即逾期天数与上次安排的复习间隔天数之比，间隔不足一天时按一天计算。
+func overdueness(card Card, now time.Time) float64 {
+	interval := max(float64(card.GetCardState().ScheduledDays), 1)
+	return now.Sub(card.GetDue()).Hours() / 24 / interval
+}
+
+// shuffleValue 返回闪卡 cardID 在随机数种子 seed 下的排序值，取值范围 [0, 1)。
+func shuffleValue(seed int64, cardID string) float64 {
+	h := fnv.New64a()
+	binary.Write(h, binary.LittleEndian, seed)
+	h.Write([]byte(cardID))
+	return float64(h.Sum64()>>11) / (1 << 53)
+}
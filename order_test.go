@@ -0,0 +1,127 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDueOrder(t *testing.T) {
+	const saveDir = "testdata"
+	os.MkdirAll(saveDir, 0755)
+	defer os.RemoveAll(saveDir)
+
+	deckID := newID()
+	opts := DefaultDeckOptions()
+	opts.Order = DueOrderDifficulty
+	clock := NewManualClock(time.Date(2023, 5, 20, 8, 0, 0, 0, time.Local))
+	deck, err := LoadDeckWithClock(saveDir, deckID, opts, clock)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 0 == deck.Options.ShuffleSeed {
+		t.Fatal("shuffle seed not generated")
+	}
+
+	ratings := []Rating{Again, Hard, Good, Easy}
+	for i := 0; i < 12; i++ {
+		cardID := newID()
+		deck.AddCard(cardID, "block"+string(rune('a'+i%3)))
+		deck.Review(cardID, ratings[i%len(ratings)])
+		clock.Advance(time.Duration(i) * time.Hour)
+		deck.Review(cardID, ratings[(i+1)%len(ratings)])
+	}
+	clock.Advance(365 * 24 * time.Hour)
+
+	isSorted := func(cards []Card, key func(card Card) float64) bool {
+		return slices.IsSortedFunc(cards, func(a, b Card) int {
+			ka, kb := key(a), key(b)
+			if ka < kb {
+				return -1
+			}
+			if ka > kb {
+				return 1
+			}
+			return 0
+		})
+	}
+	dues := func(order DueOrder, seed int64) []Card {
+		return deck.DuesWithOptions(&QueryOptions{Order: order, Seed: seed})
+	}
+	now := clock.Now()
+
+	if cards := deck.Dues(); 12 != len(cards) || !isSorted(cards, func(card Card) float64 { return -card.GetCardState().Difficulty }) {
+		t.Fatal("dues not sorted by deck order")
+	}
+	// 运行时修改闪卡包的排序策略立即生效
+	deck.Options.Order = DueOrderOverdueness
+	if !isSorted(deck.Dues(), func(card Card) float64 { return -overdueness(card, now) }) {
+		t.Fatal("dues not sorted by changed deck order")
+	}
+	if !isSorted(dues(DueOrderDue, 0), func(card Card) float64 { return float64(card.GetDue().Unix()) }) {
+		t.Fatal("dues not sorted by due")
+	}
+	if !isSorted(dues(DueOrderRetrievability, 0), func(card Card) float64 { return deck.Retrievability(card.ID(), now) }) {
+		t.Fatal("dues not sorted by retrievability")
+	}
+	if !isSorted(dues(DueOrderOverdueness, 0), func(card Card) float64 { return -overdueness(card, now) }) {
+		t.Fatal("dues not sorted by overdueness")
+	}
+
+	var blockIDs []string
+	for _, card := range dues(DueOrderBlock, 0) {
+		if 0 == len(blockIDs) || blockIDs[len(blockIDs)-1] != card.BlockID() {
+			blockIDs = append(blockIDs, card.BlockID())
+		}
+	}
+	if 3 != len(blockIDs) {
+		t.Fatalf("dues not grouped by block: %v", blockIDs)
+	}
+
+	ids := func(cards []Card) (ret []string) {
+		for _, card := range cards {
+			ret = append(ret, card.ID())
+		}
+		return
+	}
+	shuffled := ids(dues(DueOrderShuffle, 0))
+	if !slices.Equal(shuffled, ids(dues(DueOrderShuffle, 0))) {
+		t.Fatal("shuffled order changed")
+	}
+	if slices.Equal(shuffled, ids(dues(DueOrderShuffle, 42))) {
+		t.Fatal("shuffled order not changed with another seed")
+	}
+
+	// 随机数种子随闪卡包保存
+	if err = deck.Save(); nil != err {
+		t.Fatal(err)
+	}
+	if deck, err = LoadDeckWithClock(saveDir, deckID, nil, clock); nil != err {
+		t.Fatal(err)
+	}
+	if !slices.Equal(shuffled, ids(dues(DueOrderShuffle, 0))) {
+		t.Fatal("shuffle seed not persisted")
+	}
+
+	opts.Order = "unknown"
+	if nil == opts.Validate() {
+		t.Fatal("invalid due order should fail validation")
+	}
+}
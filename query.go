@@ -0,0 +1,48 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"slices"
+	"time"
+)
+
+// QueryOptions 描述了到期闪卡和新卡查询的选项，为 nil 时使用零值。
+type QueryOptions struct {
+	IncludeSuspended bool     // 是否包含已暂停的闪卡
+	IncludeBuried    bool     // 是否包含搁置中的闪卡
+	Tags             []string // 只返回带有其中任一标签的闪卡，为空时不限制
+	Order            DueOrder // 到期闪卡的排序策略，为空时使用闪卡包的排序策略
+	Seed             int64    // 打乱顺序使用的随机数种子，为 0 时使用闪卡包保存的种子
+}
+
+// match 判断闪卡 card 在 now 时是否满足查询选项。
+func (opts *QueryOptions) match(card Card, now time.Time) bool {
+	if nil == opts {
+		opts = &QueryOptions{}
+	}
+	if !opts.IncludeSuspended && card.IsSuspended() {
+		return false
+	}
+	if !opts.IncludeBuried && now.Before(card.GetBuriedUntil()) {
+		return false
+	}
+	if 0 < len(opts.Tags) && !slices.ContainsFunc(opts.Tags, card.HasTag) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,119 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSuspendAndBury(t *testing.T) {
+	const saveDir = "testdata"
+	os.MkdirAll(saveDir, 0755)
+	defer os.RemoveAll(saveDir)
+
+	clock := NewManualClock(time.Date(2023, 5, 20, 8, 0, 0, 0, time.Local))
+	deckID := newID()
+	deck, err := LoadDeckWithClock(saveDir, deckID, nil, clock)
+	if nil != err {
+		t.Fatal(err)
+	}
+	blockID := newID()
+	suspendedID, buriedID, dueID := newID(), newID(), newID()
+	for _, cardID := range []string{suspendedID, buriedID, dueID} {
+		deck.AddCard(cardID, blockID)
+	}
+	deck.Suspend(suspendedID)
+	deck.Bury(buriedID, clock.Now().AddDate(0, 0, 1))
+
+	blockIDs := []string{blockID}
+	if 1 != len(deck.Dues()) || 1 != len(deck.GetDueCardsByBlockIDs(blockIDs)) || 1 != len(deck.GetNewCardsByBlockIDs(blockIDs)) {
+		t.Fatal("suspended or buried cards should be skipped")
+	}
+	if 3 != len(deck.DuesWithOptions(&QueryOptions{IncludeSuspended: true, IncludeBuried: true})) {
+		t.Fatal("suspended and buried cards should be included")
+	}
+	if 2 != len(deck.GetNewCardsByBlockIDsWithOptions(blockIDs, &QueryOptions{IncludeBuried: true})) {
+		t.Fatal("buried cards should be included")
+	}
+
+	// 搁置到期后恢复复习，暂停状态持久化
+	clock.Advance(24 * time.Hour)
+	if 2 != len(deck.Dues()) {
+		t.Fatal("buried card should be due after burying")
+	}
+	if err = deck.Save(); nil != err {
+		t.Fatal(err)
+	}
+	if deck, err = LoadDeckWithClock(saveDir, deckID, nil, clock); nil != err {
+		t.Fatal(err)
+	}
+	if !deck.GetCard(suspendedID).IsSuspended() {
+		t.Fatal("suspended flag not persisted")
+	}
+	deck.Unsuspend(suspendedID)
+	if 3 != len(deck.GetDueCardsByBlockIDs(blockIDs)) {
+		t.Fatal("unsuspended card should be due")
+	}
+}
+
+func TestTags(t *testing.T) {
+	const saveDir = "testdata"
+	os.MkdirAll(saveDir, 0755)
+	defer os.RemoveAll(saveDir)
+
+	deckID := newID()
+	deck, err := LoadDeckWithOptions(saveDir, deckID, nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	mathID, physicsID, untaggedID := newID(), newID(), newID()
+	blockIDs := []string{newID(), newID()}
+	deck.AddCard(mathID, blockIDs[0])
+	deck.AddCard(physicsID, blockIDs[1])
+	deck.AddCard(untaggedID, blockIDs[1])
+	deck.AddTags(mathID, "math", " exam ", "math", "")
+	deck.AddTags(physicsID, "physics", "exam")
+
+	if tags := deck.GetCard(mathID).GetTags(); 2 != len(tags) || "exam" != tags[0] || "math" != tags[1] {
+		t.Fatalf("tags %v not normalized", tags)
+	}
+	if cards := deck.CardsByTag("exam"); 2 != len(cards) {
+		t.Fatalf("cards by tag [len=%d] != [2]", len(cards))
+	}
+	if cards := deck.GetNewCardsByBlockIDsWithOptions(blockIDs, &QueryOptions{Tags: []string{"physics"}}); 1 != len(cards) || physicsID != cards[0].ID() {
+		t.Fatal("new cards not filtered by tag")
+	}
+	if cards := deck.DuesWithOptions(&QueryOptions{Tags: []string{"math", "physics"}}); 2 != len(cards) {
+		t.Fatalf("dues by tags [len=%d] != [2]", len(cards))
+	}
+
+	deck.RemoveTags(mathID, "exam")
+	if cards := deck.CardsByTag("exam"); 1 != len(cards) || physicsID != cards[0].ID() {
+		t.Fatal("tag index not updated after removing tags")
+	}
+	if err = deck.Save(); nil != err {
+		t.Fatal(err)
+	}
+	if deck, err = LoadDeckWithOptions(saveDir, deckID, nil); nil != err {
+		t.Fatal(err)
+	}
+	if cards := deck.CardsByTag("math"); 1 != len(cards) || mathID != cards[0].ID() {
+		t.Fatal("tags not persisted")
+	}
+}
@@ -0,0 +1,177 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/88250/gulu"
+	"github.com/siyuan-note/filelock"
+	"github.com/siyuan-note/logging"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// 记录文件由若干条记录顺序拼接而成，每条记录为 4 字节大端序的长度加上 msgpack 编码的内容，写入时只追加不改写。
+
+const maxRecordSize = 64 * 1024 * 1024 // 单条记录的最大字节数，超过时认为文件已损坏
+
+// appendRecords 将 values 依次编码为记录追加到文件 p 末尾，文件不存在时创建。
+func appendRecords(p string, values ...interface{}) (err error) {
+	buf, err := encodeRecords(values...)
+	if nil != err {
+		return
+	}
+
+	dir := filepath.Dir(p)
+	if !gulu.File.IsDir(dir) {
+		if err = os.MkdirAll(dir, 0755); nil != err {
+			return
+		}
+	}
+
+	// 不使用 filelock.OpenFile，它在打开失败时不会释放 p 的文件锁，之后对 p 的操作都会阻塞
+	filelock.Lock(p)
+	defer filelock.Unlock(p)
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if nil != err {
+		return
+	}
+	defer func() {
+		if closeErr := f.Close(); nil == err {
+			err = closeErr
+		}
+	}()
+
+	info, err := f.Stat()
+	if nil != err {
+		return
+	}
+	size := info.Size()
+	if known, ok := recordFileSizes.Load(p); !ok || known.(int64) != size {
+		// 文件末尾可能有写入时进程崩溃留下的不完整记录，截断后再追加，否则后续追加的记录都无法读取
+		var end int64
+		if end, err = getRecordsEnd(f, size); nil != err {
+			return
+		}
+		if end < size {
+			logging.LogWarnf("truncated incomplete record at the end of [%s]", p)
+			if err = f.Truncate(end); nil != err {
+				return
+			}
+			size = end
+		}
+	}
+
+	if _, err = f.Write(buf); nil != err {
+		// 写入部分记录（比如磁盘已满）时截断回写入前的长度，避免后续追加的记录无法读取
+		f.Truncate(size)
+		recordFileSizes.Delete(p)
+		return
+	}
+	recordFileSizes.Store(p, size+int64(len(buf)))
+	return
+}
+
+// recordFileSizes 缓存了记录文件路径到最近一次追加后的文件长度，长度未变时不需要重新检查文件末尾是否有不完整的记录。
+var recordFileSizes = sync.Map{}
+
+// getRecordsEnd 返回长度为 size 的记录文件 f 中最后一条完整记录的结束位置。
+func getRecordsEnd(f *os.File, size int64) (ret int64, err error) {
+	header := make([]byte, 4)
+	for ret+4 <= size {
+		if _, err = f.ReadAt(header, ret); nil != err {
+			return
+		}
+		recordSize := binary.BigEndian.Uint32(header)
+		if maxRecordSize < recordSize {
+			err = errors.New("record too large, file [" + f.Name() + "] may be corrupted")
+			return
+		}
+		if size < ret+4+int64(recordSize) {
+			break
+		}
+		ret += 4 + int64(recordSize)
+	}
+	return
+}
+
+// encodeRecords 将 values 依次编码为记录。
+func encodeRecords(values ...interface{}) (ret []byte, err error) {
+	for _, v := range values {
+		var data []byte
+		if data, err = msgpack.Marshal(v); nil != err {
+			return
+		}
+		ret = binary.BigEndian.AppendUint32(ret, uint32(len(data)))
+		ret = append(ret, data...)
+	}
+	return
+}
+
+// readRecords 按写入顺序读取文件 p 中的记录并交给 fn 处理，fn 返回 false 时停止读取。
+//
+// 文件不存在时不做任何处理，末尾不完整的记录（比如写入时进程崩溃）会被忽略，并在下次追加记录时截断。
+func readRecords(p string, fn func(data []byte) bool) (err error) {
+	filelock.Lock(p)
+	defer filelock.Unlock(p)
+	f, err := os.Open(p)
+	if nil != err {
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		return
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	header := make([]byte, 4)
+	for {
+		if _, err = io.ReadFull(reader, header); nil != err {
+			if errors.Is(err, io.EOF) {
+				err = nil
+			} else if errors.Is(err, io.ErrUnexpectedEOF) {
+				logging.LogWarnf("ignored incomplete record at the end of [%s]", p)
+				err = nil
+			}
+			return
+		}
+
+		size := binary.BigEndian.Uint32(header)
+		if maxRecordSize < size {
+			err = errors.New("record too large, file [" + p + "] may be corrupted")
+			return
+		}
+		data := make([]byte, size)
+		if _, err = io.ReadFull(reader, data); nil != err {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				logging.LogWarnf("ignored incomplete record at the end of [%s]", p)
+				err = nil
+			}
+			return
+		}
+
+		if !fn(data) {
+			return
+		}
+	}
+}
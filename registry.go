@@ -0,0 +1,142 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// StoreFactory 描述了闪卡存储的构造函数，id 为卡包 ID，saveDir 为数据文件夹路径，opts 为闪卡包选项。
+type StoreFactory func(id, saveDir string, opts *DeckOptions) (Store, error)
+
+// SchedulerFactory 描述了调度算法的构造函数，opts 为闪卡包选项。
+type SchedulerFactory func(opts *DeckOptions) (Scheduler, error)
+
+var (
+	storeFactories     = map[Algo]StoreFactory{}
+	storeFactoriesLock = sync.RWMutex{}
+
+	schedulerFactories     = map[Algo]SchedulerFactory{}
+	schedulerFactoriesLock = sync.RWMutex{}
+)
+
+func init() {
+	RegisterStore(AlgoFSRS, func(id, saveDir string, opts *DeckOptions) (Store, error) {
+		return NewFSRSStoreWithOptions(id, saveDir, opts)
+	})
+	RegisterStore(AlgoSM2, func(id, saveDir string, opts *DeckOptions) (Store, error) {
+		return NewSM2StoreWithOptions(id, saveDir, opts)
+	})
+
+	RegisterScheduler(AlgoFSRS, func(opts *DeckOptions) (Scheduler, error) {
+		return NewFSRSSchedulerWithParams(&opts.FSRS)
+	})
+	RegisterScheduler(AlgoSM2, func(opts *DeckOptions) (Scheduler, error) {
+		if err := opts.SM2.Validate(); nil != err {
+			return nil, err
+		}
+		return NewSM2Scheduler(opts.SM2.MaximumInterval), nil
+	})
+}
+
+// RegisterStore 注册算法 algo 对应的闪卡存储构造函数，重复注册时覆盖之前的构造函数。
+func RegisterStore(algo Algo, factory StoreFactory) {
+	if "" == algo {
+		panic("riff: register store with empty algo")
+	}
+	if nil == factory {
+		panic("riff: register store [" + string(algo) + "] with nil factory")
+	}
+
+	storeFactoriesLock.Lock()
+	defer storeFactoriesLock.Unlock()
+	storeFactories[algo] = factory
+}
+
+// UnregisterStore 注销算法 algo 对应的闪卡存储构造函数。
+func UnregisterStore(algo Algo) {
+	storeFactoriesLock.Lock()
+	defer storeFactoriesLock.Unlock()
+	delete(storeFactories, algo)
+}
+
+// RegisterScheduler 注册算法 algo 对应的调度算法构造函数，重复注册时覆盖之前的构造函数。
+//
+// 调度算法用于脱离闪卡包的计算，比如模拟复习，注册闪卡存储的算法也应该注册对应的调度算法。
+func RegisterScheduler(algo Algo, factory SchedulerFactory) {
+	if "" == algo {
+		panic("riff: register scheduler with empty algo")
+	}
+	if nil == factory {
+		panic("riff: register scheduler [" + string(algo) + "] with nil factory")
+	}
+
+	schedulerFactoriesLock.Lock()
+	defer schedulerFactoriesLock.Unlock()
+	schedulerFactories[algo] = factory
+}
+
+// UnregisterScheduler 注销算法 algo 对应的调度算法构造函数。
+func UnregisterScheduler(algo Algo) {
+	schedulerFactoriesLock.Lock()
+	defer schedulerFactoriesLock.Unlock()
+	delete(schedulerFactories, algo)
+}
+
+// Algos 返回所有已注册的算法名称。
+func Algos() (ret []Algo) {
+	storeFactoriesLock.RLock()
+	defer storeFactoriesLock.RUnlock()
+
+	for algo := range storeFactories {
+		ret = append(ret, algo)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+	return
+}
+
+// NewStore 使用算法 algo 注册的构造函数新建闪卡存储。
+func NewStore(algo Algo, id, saveDir string, opts *DeckOptions) (ret Store, err error) {
+	storeFactoriesLock.RLock()
+	factory := storeFactories[algo]
+	storeFactoriesLock.RUnlock()
+
+	if nil == factory {
+		err = fmt.Errorf("algo [%s] not supported yet", algo)
+		return
+	}
+	return factory(id, saveDir, opts)
+}
+
+// NewScheduler 使用闪卡包选项 opts 中的算法注册的构造函数和参数新建调度算法，比如用于模拟不同参数下的复习。
+func NewScheduler(opts *DeckOptions) (ret Scheduler, err error) {
+	if err = opts.Validate(); nil != err {
+		return
+	}
+
+	schedulerFactoriesLock.RLock()
+	factory := schedulerFactories[opts.Algo]
+	schedulerFactoriesLock.RUnlock()
+
+	if nil == factory {
+		err = fmt.Errorf("algo [%s] scheduler not supported yet", opts.Algo)
+		return
+	}
+	return factory(opts)
+}
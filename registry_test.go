@@ -0,0 +1,103 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"os"
+	"testing"
+)
+
+func TestRegisterStore(t *testing.T) {
+	const saveDir = "testdata"
+	os.MkdirAll(saveDir, 0755)
+	defer os.RemoveAll(saveDir)
+
+	const algo Algo = "custom"
+	opts := DefaultDeckOptions()
+	opts.Algo = algo
+	if _, err := LoadDeckWithOptions(saveDir, newID(), opts); nil == err {
+		t.Fatalf("load deck with unregistered algo [%s] should fail", algo)
+	}
+
+	called := false
+	RegisterStore(algo, func(id, saveDir string, opts *DeckOptions) (Store, error) {
+		called = true
+		return NewSM2Store(id, saveDir, opts.SM2.MaximumInterval), nil
+	})
+	defer UnregisterStore(algo)
+
+	deck, err := LoadDeckWithOptions(saveDir, newID(), opts)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if !called {
+		t.Fatalf("factory of algo [%s] not called", algo)
+	}
+	if algo != deck.Algo {
+		t.Fatalf("deck algo [%s] != [%s]", deck.Algo, algo)
+	}
+
+	// 只注册了闪卡存储的算法也可以统计可提取性和模拟预测
+	cardID := newID()
+	deck.AddCard(cardID, newID())
+	deck.Review(cardID, Good)
+	if stats, err := deck.RetrievabilityStats(deck.Now(), 0); nil != err || 1 != stats.Count {
+		t.Fatalf("retrievability stats [%+v, err=%v]", stats, err)
+	}
+	if forecast, err := deck.ForecastWithOptions(7, &ForecastOptions{Simulate: true}); nil != err || 7 != len(forecast) {
+		t.Fatalf("simulated forecast %v [err=%v]", forecast, err)
+	}
+
+	found := false
+	for _, a := range Algos() {
+		if algo == a {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("algo [%s] not registered", algo)
+	}
+}
+
+func TestRegisterScheduler(t *testing.T) {
+	for _, algo := range []Algo{AlgoFSRS, AlgoSM2} {
+		opts := DefaultDeckOptions()
+		opts.Algo = algo
+		scheduler, err := NewScheduler(opts)
+		if nil != err {
+			t.Fatal(err)
+		}
+		if algo != scheduler.Algo() {
+			t.Fatalf("scheduler algo [%s] != [%s]", scheduler.Algo(), algo)
+		}
+	}
+
+	const algo Algo = "custom"
+	opts := DefaultDeckOptions()
+	opts.Algo = algo
+	if _, err := NewScheduler(opts); nil == err {
+		t.Fatalf("new scheduler with unregistered algo [%s] should fail", algo)
+	}
+
+	RegisterScheduler(algo, func(opts *DeckOptions) (Scheduler, error) {
+		return NewSM2Scheduler(opts.SM2.MaximumInterval), nil
+	})
+	defer UnregisterScheduler(algo)
+	if _, err := NewScheduler(opts); nil != err {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,86 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"math"
+	"time"
+
+	"github.com/siyuan-note/logging"
+)
+
+const defaultRetrievabilityBuckets = 10 // 可提取性分布的默认区间数
+
+// RetrievabilityStats 描述了闪卡包中已复习过的闪卡在某一时刻的可提取性（回忆概率）统计。
+type RetrievabilityStats struct {
+	Count     int     // 参与统计的闪卡数，不包括新卡
+	Average   float64 // 平均可提取性
+	Histogram []int   // 可提取性分布，第 i 个元素为可提取性在 [i/n, (i+1)/n) 区间内的闪卡数，1 计入最后一个区间
+}
+
+// add 将可提取性 r 计入统计。
+func (stats *RetrievabilityStats) add(r float64) {
+	n := len(stats.Histogram)
+	i := int(math.Floor(r * float64(n)))
+	i = max(0, min(i, n-1))
+	stats.Histogram[i]++
+	stats.Average += (r - stats.Average) / float64(stats.Count+1)
+	stats.Count++
+}
+
+func (store *BaseStore) Retrievability(id string, at time.Time) float64 {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	card := store.cards[id]
+	if nil == card || card.GetLastReview().IsZero() {
+		return 0
+	}
+	return retrievability(store.scheduler, card, at)
+}
+
+// RetrievabilityStats 返回闪卡包中已复习过的闪卡在 at 时的平均可提取性和分布，buckets 为分布的区间数，小于 1 时使用默认的 10 个区间。
+//
+// 可提取性使用闪卡包存储的调度算法计算，和 Retrievability 一致。
+func (deck *Deck) RetrievabilityStats(at time.Time, buckets int) (ret *RetrievabilityStats, err error) {
+	deck.lock.Lock()
+	defer deck.lock.Unlock()
+
+	if 1 > buckets {
+		buckets = defaultRetrievabilityBuckets
+	}
+	stats := &RetrievabilityStats{Histogram: make([]int, buckets)}
+	if err = deck.store.WithScheduler(func(scheduler Scheduler, cards []Card) error {
+		for _, card := range cards {
+			if card.GetLastReview().IsZero() {
+				continue
+			}
+			stats.add(retrievability(scheduler, card, at))
+		}
+		return nil
+	}); nil != err {
+		logging.LogErrorf("stat retrievability of deck [%s] failed: %s", deck.Name, err)
+		return
+	}
+	ret = stats
+	return
+}
+
+// retrievability 返回闪卡 card 在 at 时的可提取性，限制在 [0, 1] 之间，比如 at 早于最后复习时间时调度算法可能返回大于 1 的值。
+func retrievability(scheduler Scheduler, card Card, at time.Time) float64 {
+	return max(0, min(scheduler.Retrievability(card, at), 1))
+}
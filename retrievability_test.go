@@ -0,0 +1,62 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRetrievability(t *testing.T) {
+	const saveDir = "testdata"
+	os.MkdirAll(saveDir, 0755)
+	defer os.RemoveAll(saveDir)
+
+	deck, err := LoadDeckWithOptions(saveDir, newID(), nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	newCardID, reviewedID := newID(), newID()
+	deck.AddCard(newCardID, newID())
+	deck.AddCard(reviewedID, newID())
+	deck.Review(reviewedID, Good)
+
+	if r := deck.Retrievability(newCardID, time.Now()); 0 != r {
+		t.Fatalf("new card retrievability [%f] != [0]", r)
+	}
+	now := deck.Retrievability(reviewedID, time.Now())
+	later := deck.Retrievability(reviewedID, time.Now().AddDate(0, 0, 30))
+	if 0.9 > now || later >= now {
+		t.Fatalf("retrievability [%f] then [%f] should decay", now, later)
+	}
+	if r := deck.Retrievability(reviewedID, time.Now().AddDate(0, 0, -1)); 1 < r {
+		t.Fatalf("retrievability before last review [%f] > [1]", r)
+	}
+
+	stats, err := deck.RetrievabilityStats(time.Now(), 0)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 1 != stats.Count || 10 != len(stats.Histogram) || 1 != stats.Histogram[9] {
+		t.Fatalf("retrievability stats %+v mismatched", stats)
+	}
+	if 0.01 < math.Abs(stats.Average-now) {
+		t.Fatalf("average retrievability [%f] != [%f]", stats.Average, now)
+	}
+}
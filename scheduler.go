@@ -0,0 +1,38 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import "time"
+
+// Scheduler 描述了间隔重复调度算法，只负责计算闪卡的复习状态，不涉及闪卡的保存。
+type Scheduler interface {
+
+	// Algo 返回算法名称，如：fsrs。
+	Algo() Algo
+
+	// NewCard 新建一张该算法的闪卡，存储加载闪卡时也通过它获得反序列化的目标。
+	NewCard(id, blockID string) Card
+
+	// Preview 返回闪卡在 now 时以每种评分复习后的下次到期时间，不修改闪卡。
+	Preview(card Card, now time.Time) map[Rating]time.Time
+
+	// Repeat 在 now 时以 rating 评分复习闪卡，更新闪卡状态并返回复习日志。
+	Repeat(card Card, now time.Time, rating Rating) *Log
+
+	// Retrievability 返回闪卡在 now 时的可提取性（回忆概率），新卡返回 0。
+	Retrievability(card Card, now time.Time) float64
+}
@@ -0,0 +1,87 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedulers(t *testing.T) {
+	schedulers := []Scheduler{
+		NewFSRSScheduler(requestRetention, maximumInterval, weights),
+		NewSM2Scheduler(maximumInterval),
+	}
+
+	now := time.Now()
+	for _, scheduler := range schedulers {
+		cardID, blockID := newID(), newID()
+		card := scheduler.NewCard(cardID, blockID)
+		if cardID != card.ID() || blockID != card.BlockID() {
+			t.Fatalf("[%s] new card [id=%s, blockID=%s]", scheduler.Algo(), card.ID(), card.BlockID())
+		}
+		if 0 != scheduler.Retrievability(card, now) {
+			t.Fatalf("[%s] retrievability of new card [%f] != [0]", scheduler.Algo(), scheduler.Retrievability(card, now))
+		}
+
+		nextDues := scheduler.Preview(card, now)
+		if 4 != len(nextDues) {
+			t.Fatalf("[%s] preview [len=%d]", scheduler.Algo(), len(nextDues))
+		}
+		if 0 != card.GetReps() {
+			t.Fatalf("[%s] preview changed card reps [%d]", scheduler.Algo(), card.GetReps())
+		}
+
+		log := scheduler.Repeat(card, now, Easy)
+		if nil == log || cardID != log.CardID || Easy != log.Rating || New != log.State {
+			t.Fatalf("[%s] repeat log [%+v]", scheduler.Algo(), log)
+		}
+		if !card.GetDue().Equal(nextDues[Easy]) {
+			t.Fatalf("[%s] card due [%s] != preview due [%s]", scheduler.Algo(), card.GetDue(), nextDues[Easy])
+		}
+
+		state := card.GetCardState()
+		if !state.Due.Equal(card.GetDue()) || 1 != state.Reps || !state.LastReview.Equal(now) || 0 >= state.Stability {
+			t.Fatalf("[%s] card state [%+v]", scheduler.Algo(), state)
+		}
+
+		r := scheduler.Retrievability(card, now.Add(24*time.Hour))
+		if 0 >= r || 1 < r {
+			t.Fatalf("[%s] retrievability [%f]", scheduler.Algo(), r)
+		}
+	}
+}
+
+func TestReviewInvalidRating(t *testing.T) {
+	stores := []Store{
+		NewFSRSStore("test-invalid-rating", "testdata", requestRetention, maximumInterval, weights),
+		NewSM2Store("test-invalid-rating", "testdata", maximumInterval),
+	}
+
+	for _, store := range stores {
+		cardID := newID()
+		store.AddCard(cardID, newID())
+		for _, rating := range []Rating{0, Easy + 1} {
+			if log := store.Review(cardID, rating); nil != log {
+				t.Fatalf("[%s] reviewed with invalid rating [%d]", store.Algo(), rating)
+			}
+		}
+		if card := store.GetCard(cardID); 0 != card.GetReps() || New != card.GetState() {
+			t.Fatalf("[%s] card changed by invalid ratings", store.Algo())
+		}
+	}
+}
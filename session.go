@@ -0,0 +1,247 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// InterleavePolicy 描述了学习会话中复习卡和新卡的穿插策略，学习中的闪卡到期后总是优先出现。
+type InterleavePolicy int
+
+const (
+	InterleaveMixed        InterleavePolicy = iota // 新卡均匀地穿插在复习卡之间
+	InterleaveReviewsFirst                         // 先复习完复习卡再学习新卡
+	InterleaveNewFirst                             // 先学习完新卡再复习复习卡
+)
+
+const (
+	defaultSessionNewLimit    = 20  // 默认每天最多学习的新卡数
+	defaultSessionReviewLimit = 200 // 默认每天最多的复习数
+)
+
+// ErrNoCurrentCard 描述了学习会话中没有待回答的闪卡。
+var ErrNoCurrentCard = errors.New("no current card to answer, call Next first")
+
+// SessionOptions 描述了学习会话的选项。
+type SessionOptions struct {
+	NewLimit    int              // 每天最多学习的新卡数，包括今天已经学习的新卡，小于 0 时不限制
+	ReviewLimit int              // 每天最多的复习数（复习状态的闪卡），包括今天已经复习的，小于 0 时不限制
+	Policy      InterleavePolicy // 复习卡和新卡的穿插策略
+	BlockIDs    []string         // 只学习这些内容块的闪卡，为空时不限制
+	Query       *QueryOptions    // 查询选项，比如只学习带有某些标签的闪卡
+}
+
+// DefaultSessionOptions 返回默认的学习会话选项。
+func DefaultSessionOptions() *SessionOptions {
+	return &SessionOptions{
+		NewLimit:    defaultSessionNewLimit,
+		ReviewLimit: defaultSessionReviewLimit,
+		Policy:      InterleaveMixed,
+	}
+}
+
+// Session 描述了一次学习会话，按每日上限和穿插策略逐张给出闪卡。
+//
+// 会话不是并发安全的，同一个闪卡包上同时只应该有一个会话。
+type Session struct {
+	deck     *Deck
+	opts     *SessionOptions
+	learning []Card // 学习中的闪卡，按到期时间排序
+	reviews  []Card // 复习卡，按闪卡包或者查询选项的排序策略排序
+	news     []Card // 新卡
+
+	current         Card // 待回答的闪卡
+	reviewsSinceNew int  // 上一张新卡之后给出的复习卡数
+}
+
+// NewSession 在闪卡包 deck 上新建学习会话，opts 为 nil 时使用默认选项。
+//
+// 每日上限扣除今天的复习日志中已经学习的新卡数和已经复习的复习卡数。
+func NewSession(deck *Deck, opts *SessionOptions) (ret *Session, err error) {
+	if nil == opts {
+		opts = DefaultSessionOptions()
+	}
+
+	ret = &Session{deck: deck, opts: opts}
+	now := deck.getClock().Now()
+	newCount, reviewCount, err := countTodayReviews(deck, now)
+	if nil != err {
+		return
+	}
+
+	// 学习中的闪卡包括今天稍后才到期的（比如上次会话中刚刚回答 Again 的闪卡），它们到期后才会给出
+	blockIDs := opts.BlockIDs
+	if 1 > len(blockIDs) {
+		blockIDs = deck.GetBlockIDs()
+	}
+	dayEnd := getDayStart(now).AddDate(0, 0, 1)
+	for _, card := range deck.GetCardsByBlockIDs(blockIDs) {
+		if state := card.GetState(); (Learning == state || Relearning == state) && card.GetDue().Before(dayEnd) && opts.Query.match(card, now) {
+			ret.learning = append(ret.learning, card)
+		}
+	}
+
+	var dues []Card
+	if 0 < len(opts.BlockIDs) {
+		dues = append(deck.GetDueCardsByBlockIDsWithOptions(opts.BlockIDs, opts.Query), deck.GetNewCardsByBlockIDsWithOptions(opts.BlockIDs, opts.Query)...)
+	} else {
+		dues = deck.DuesWithOptions(opts.Query)
+	}
+
+	visited := map[string]bool{}
+	for _, card := range dues {
+		if visited[card.ID()] {
+			continue
+		}
+		visited[card.ID()] = true
+
+		switch card.GetState() {
+		case New:
+			ret.news = append(ret.news, card)
+		case Learning, Relearning:
+			// 学习中的闪卡已经在上面收集
+		default:
+			ret.reviews = append(ret.reviews, card)
+		}
+	}
+	sortByDue(ret.learning)
+	sort.SliceStable(ret.news, func(i, j int) bool { return ret.news[i].ID() < ret.news[j].ID() })
+
+	ret.news = limitCards(ret.news, opts.NewLimit, newCount)
+	ret.reviews = limitCards(ret.reviews, opts.ReviewLimit, reviewCount)
+	return
+}
+
+// Next 返回下一张需要学习的闪卡，上一张闪卡还没有回答时再次返回该闪卡，当前没有需要学习的闪卡时返回 nil。
+//
+// 学习中的闪卡（比如刚刚回答 Again 的闪卡）在到期之后才会再次出现，所以返回 nil 不代表会话已经结束，
+// 学习中的闪卡数不为 0 时（见 Counts）稍后还会有闪卡需要学习。
+func (session *Session) Next() Card {
+	if nil != session.current {
+		return session.current
+	}
+
+	now := session.deck.getClock().Now()
+	if 0 < len(session.learning) && !now.Before(session.learning[0].GetDue()) {
+		session.current, session.learning = session.learning[0], session.learning[1:]
+		return session.current
+	}
+
+	if session.nextIsNew() {
+		session.current, session.news = session.news[0], session.news[1:]
+		session.reviewsSinceNew = 0
+	} else if 0 < len(session.reviews) {
+		session.current, session.reviews = session.reviews[0], session.reviews[1:]
+		session.reviewsSinceNew++
+	}
+	return session.current
+}
+
+// Answer 使用评分 rating 回答 Next 返回的闪卡并保存复习日志，回答后仍在学习中并且今天到期的闪卡会再次出现。
+//
+// 闪卡已经被删除时返回错误并跳过该闪卡。
+func (session *Session) Answer(rating Rating) (ret *Log, err error) {
+	if nil == session.current {
+		err = ErrNoCurrentCard
+		return
+	}
+
+	card := session.current
+	session.current = nil
+	if ret = session.deck.Review(card.ID(), rating); nil == ret {
+		// 闪卡在会话过程中被删除时跳过
+		err = errors.New("review card [" + card.ID() + "] failed, it may have been removed")
+		return
+	}
+	if err = session.deck.SaveLog(ret); nil != err {
+		return
+	}
+
+	if card = session.deck.GetCard(card.ID()); nil == card {
+		return
+	}
+	if state := card.GetState(); Learning == state || Relearning == state {
+		now := session.deck.getClock().Now()
+		if card.GetDue().Before(getDayStart(now).AddDate(0, 0, 1)) {
+			session.learning = append(session.learning, card)
+			sortByDue(session.learning)
+		}
+	}
+	return
+}
+
+// Counts 返回会话中剩余的学习中、复习和新卡的数量，不包括待回答的闪卡。
+func (session *Session) Counts() (learning, reviews, news int) {
+	return len(session.learning), len(session.reviews), len(session.news)
+}
+
+// nextIsNew 按穿插策略判断下一张是否给出新卡。
+func (session *Session) nextIsNew() bool {
+	if 1 > len(session.news) {
+		return false
+	}
+	if 1 > len(session.reviews) {
+		return true
+	}
+
+	switch session.opts.Policy {
+	case InterleaveNewFirst:
+		return true
+	case InterleaveReviewsFirst:
+		return false
+	default:
+		// 每给出 len(reviews)/len(news) 张复习卡后给出一张新卡，使新卡均匀分布
+		return len(session.reviews)/len(session.news) <= session.reviewsSinceNew
+	}
+}
+
+// countTodayReviews 从复习日志中统计闪卡包 deck 在 now 所在的这一天已经学习的新卡数和已经复习的复习卡数。
+func countTodayReviews(deck *Deck, now time.Time) (newCount, reviewCount int, err error) {
+	query := &LogQuery{States: []State{New, Review}, Start: getDayStart(now), End: now.Add(time.Second)}
+	err = deck.Logs(query, func(log *Log) bool {
+		if New == log.State {
+			newCount++
+		} else {
+			reviewCount++
+		}
+		return true
+	})
+	return
+}
+
+// limitCards 返回 cards 中不超过每日上限 limit 的部分，done 为今天已经完成的数量。
+func limitCards(cards []Card, limit, done int) []Card {
+	if 0 > limit {
+		return cards
+	}
+	remain := max(limit-done, 0)
+	if remain < len(cards) {
+		return cards[:remain]
+	}
+	return cards
+}
+
+func sortByDue(cards []Card) {
+	sort.SliceStable(cards, func(i, j int) bool { return cards[i].GetDue().Before(cards[j].GetDue()) })
+}
+
+func getDayStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,139 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSession(t *testing.T) {
+	const saveDir = "testdata"
+	os.MkdirAll(saveDir, 0755)
+	defer os.RemoveAll(saveDir)
+
+	clock := NewManualClock(time.Date(2023, 5, 20, 8, 0, 0, 0, time.Local))
+	deck, err := LoadDeckWithClock(saveDir, newID(), nil, clock)
+	if nil != err {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		cardID := newID()
+		deck.AddCard(cardID, newID())
+		deck.Review(cardID, Easy)
+	}
+	clock.Advance(60 * 24 * time.Hour)
+	for i := 0; i < 5; i++ {
+		deck.AddCard(newID(), newID())
+	}
+	// 今天已经复习过一张复习卡
+	if err = deck.SaveLog(&Log{ID: newID(), CardID: newID(), Rating: Good, State: Review, Reviewed: clock.Now().Add(-time.Hour).Unix()}); nil != err {
+		t.Fatal(err)
+	}
+
+	opts := &SessionOptions{NewLimit: 2, ReviewLimit: 4, Policy: InterleaveMixed}
+	session, err := NewSession(deck, opts)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if _, err = session.Answer(Good); ErrNoCurrentCard != err {
+		t.Fatalf("answer without current card [err=%v]", err)
+	}
+	if _, reviews, news := session.Counts(); 3 != reviews || 2 != news {
+		t.Fatalf("session counts [reviews=%d, news=%d] mismatched", reviews, news)
+	}
+
+	var states []State
+	for card := session.Next(); nil != card; card = session.Next() {
+		if session.Next() != card {
+			t.Fatal("unanswered card should be returned again")
+		}
+		states = append(states, card.GetState())
+		if _, err = session.Answer(Good); nil != err {
+			t.Fatal(err)
+		}
+	}
+	expected := []State{Review, New, Review, New, Review}
+	if len(expected) != len(states) {
+		t.Fatalf("session states %v != %v", states, expected)
+	}
+	for i := range expected {
+		if expected[i] != states[i] {
+			t.Fatalf("session states %v != %v", states, expected)
+		}
+	}
+
+	// 学习中的新卡到期后再次出现
+	if learning, _, _ := session.Counts(); 2 != learning {
+		t.Fatalf("learning count [%d] != [2]", learning)
+	}
+	clock.Advance(time.Hour)
+	if card := session.Next(); nil == card || Learning != card.GetState() {
+		t.Fatal("learning card should be due again")
+	}
+
+	// 同一天新建的会话扣除已经完成的数量
+	if session, err = NewSession(deck, opts); nil != err {
+		t.Fatal(err)
+	}
+	if learning, reviews, news := session.Counts(); 2 != learning || 0 != reviews || 0 != news {
+		t.Fatalf("session counts [learning=%d, reviews=%d, news=%d] mismatched", learning, reviews, news)
+	}
+}
+
+func TestSessionLearningAndRemovedCards(t *testing.T) {
+	const saveDir = "testdata"
+	os.MkdirAll(saveDir, 0755)
+	defer os.RemoveAll(saveDir)
+
+	clock := NewManualClock(time.Date(2023, 5, 20, 8, 0, 0, 0, time.Local))
+	deck, err := LoadDeckWithClock(saveDir, newID(), nil, clock)
+	if nil != err {
+		t.Fatal(err)
+	}
+	learningID, removedID := newID(), newID()
+	deck.AddCard(learningID, newID())
+	deck.AddCard(removedID, newID())
+	deck.Review(learningID, Again)
+
+	// 上次会话中回答 Again 的闪卡稍后到期，新会话也会包含它
+	session, err := NewSession(deck, nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if learning, _, news := session.Counts(); 1 != learning || 1 != news {
+		t.Fatalf("session counts [learning=%d, news=%d] mismatched", learning, news)
+	}
+
+	// 会话过程中被删除的闪卡会被跳过
+	if card := session.Next(); nil == card || removedID != card.ID() {
+		t.Fatal("new card should be returned first")
+	}
+	deck.RemoveCard(removedID)
+	if _, err = session.Answer(Good); nil == err {
+		t.Fatal("answer removed card should fail")
+	}
+	if card := session.Next(); nil != card {
+		t.Fatalf("card [%s] should not be returned before the learning card is due", card.ID())
+	}
+
+	clock.Advance(time.Hour)
+	if card := session.Next(); nil == card || learningID != card.ID() {
+		t.Fatal("learning card should be returned after it is due")
+	}
+}
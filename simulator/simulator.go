@@ -0,0 +1,282 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package simulator 在闪卡包的快照上模拟若干天的复习，用于评估不同参数和每日上限下的复习量和记忆效果。
+//
+// 每次复习是否回忆成功按调度算法给出的可提取性（回忆概率）抽样，回忆成功时再按配置的概率抽样 Hard、Good 或者 Easy 评分。
+package simulator
+
+import (
+	"container/heap"
+	"errors"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/siyuan-note/riff"
+)
+
+// Config 描述了模拟的配置。
+type Config struct {
+	Days             int               // 模拟的天数
+	NewCardsPerDay   int               // 每天最多学习的新卡数，0 表示不学习新卡
+	MaxReviewsPerDay int               // 每天最多的复习数（不含新卡），0 表示不限制
+	Options          *riff.DeckOptions // 模拟使用的闪卡包选项，比如调整后的 requestRetention 和 maximumInterval，为 nil 时使用闪卡包的选项
+	Start            time.Time         // 模拟开始的时间，零值时使用闪卡包时钟的当前时间，没有闪卡包时使用系统当前时间
+	Seed             int64             // 随机数种子，相同的种子和输入得到相同的结果
+
+	FirstRatingProbs  [4]float64 // 新卡第一次复习时 Again、Hard、Good、Easy 的概率，全为 0 时使用默认值
+	RecallRatingProbs [3]float64 // 回忆成功时 Hard、Good、Easy 的概率，全为 0 时使用默认值
+}
+
+var defaultFirstRatingProbs = [4]float64{0.24, 0.094, 0.495, 0.171}
+
+const maxReviewsPerCardPerDay = 32 // 单张闪卡每天最多的复习次数，避免学习步骤过短时无法结束
+
+// DayStats 描述了一天的模拟结果。
+type DayStats struct {
+	Date      time.Time // 日期
+	Reviews   int       // 复习数（不含新卡）
+	NewCards  int       // 学习的新卡数
+	Recalled  int       // 复习中回忆成功的次数
+	Retention float64   // 复习的回忆成功率，没有复习时为 0
+	Knowledge float64   // 当天结束时所有已学习闪卡的可提取性之和，即期望记住的闪卡数
+}
+
+// Result 描述了模拟结果。
+type Result struct {
+	Days          []*DayStats // 每天的模拟结果
+	TotalReviews  int         // 总复习数（不含新卡）
+	TotalNewCards int         // 学习的新卡总数
+	Retention     float64     // 所有复习的回忆成功率
+	Knowledge     float64     // 模拟结束时期望记住的闪卡数
+}
+
+// Simulate 在闪卡包 deck 的快照上按配置 cfg 模拟复习，不会修改闪卡包。
+func Simulate(deck *riff.Deck, cfg *Config) (ret *Result, err error) {
+	if nil == cfg {
+		err = errors.New("simulator config is nil")
+		return
+	}
+
+	deckOpts := deck.GetOptions()
+	opts := cfg.Options
+	if nil == opts {
+		opts = deckOpts
+	}
+	if opts.Algo != deckOpts.Algo {
+		err = errors.New("simulator options algo must be the same as the deck algo [" + string(deckOpts.Algo) + "]")
+		return
+	}
+	scheduler, err := riff.NewScheduler(opts)
+	if nil != err {
+		return
+	}
+	if cfg.Start.IsZero() {
+		withStart := *cfg
+		withStart.Start = deck.Now()
+		cfg = &withStart
+	}
+	return SimulateCards(deck.Snapshot(), scheduler, cfg)
+}
+
+// SimulateCards 使用调度算法 scheduler 在闪卡 cards 上按配置 cfg 模拟复习，cards 会被修改。
+func SimulateCards(cards []riff.Card, scheduler riff.Scheduler, cfg *Config) (ret *Result, err error) {
+	if nil == cfg {
+		err = errors.New("simulator config is nil")
+		return
+	}
+	if 1 > cfg.Days {
+		err = errors.New("simulator days must be greater than 0")
+		return
+	}
+
+	s := newSimulation(cards, scheduler, cfg)
+	ret = &Result{}
+	recalled := 0
+	for day := 0; day < cfg.Days; day++ {
+		stats := s.simulateDay(s.start.AddDate(0, 0, day))
+		ret.Days = append(ret.Days, stats)
+		ret.TotalReviews += stats.Reviews
+		ret.TotalNewCards += stats.NewCards
+		recalled += stats.Recalled
+		ret.Knowledge = stats.Knowledge
+	}
+	if 0 < ret.TotalReviews {
+		ret.Retention = float64(recalled) / float64(ret.TotalReviews)
+	}
+	return
+}
+
+// simulation 描述了一次模拟的状态。
+type simulation struct {
+	cfg               *Config
+	scheduler         riff.Scheduler
+	rand              *rand.Rand
+	start             time.Time
+	learned           dueQueue    // 已经学习过的闪卡，按到期时间排序
+	news              []riff.Card // 尚未学习的新卡，按 ID 排序，即按制卡时间先后排序
+	firstRatingProbs  []float64
+	recallRatingProbs []float64
+}
+
+func newSimulation(cards []riff.Card, scheduler riff.Scheduler, cfg *Config) (ret *simulation) {
+	ret = &simulation{
+		cfg:               cfg,
+		scheduler:         scheduler,
+		rand:              rand.New(rand.NewSource(cfg.Seed)),
+		start:             cfg.Start,
+		firstRatingProbs:  cfg.FirstRatingProbs[:],
+		recallRatingProbs: cfg.RecallRatingProbs[:],
+	}
+	if ret.start.IsZero() {
+		ret.start = time.Now()
+	}
+	if isZeroProbs(ret.firstRatingProbs) {
+		ret.firstRatingProbs = defaultFirstRatingProbs[:]
+	}
+	if isZeroProbs(ret.recallRatingProbs) {
+		defaults := riff.DefaultRecallRatingProbs()
+		ret.recallRatingProbs = defaults[:]
+	}
+
+	for _, card := range cards {
+		if card.IsSuspended() {
+			continue
+		}
+		if card.GetLastReview().IsZero() {
+			ret.news = append(ret.news, card)
+			continue
+		}
+
+		// 搁置的闪卡在搁置结束之后才会复习
+		if buriedUntil := card.GetBuriedUntil(); buriedUntil.After(card.GetDue()) {
+			card.SetDue(buriedUntil)
+		}
+		ret.learned = append(ret.learned, card)
+	}
+	heap.Init(&ret.learned)
+	sort.Slice(ret.news, func(i, j int) bool { return ret.news[i].ID() < ret.news[j].ID() })
+	return
+}
+
+// simulateDay 模拟从 dayStart 开始的一天：先学习新卡，再按到期时间先后复习当天到期的闪卡，当天重新到期的闪卡（比如学习步骤）会继续复习。
+func (s *simulation) simulateDay(dayStart time.Time) (ret *DayStats) {
+	ret = &DayStats{Date: dayStart}
+	dayEnd := dayStart.AddDate(0, 0, 1)
+
+	// 搁置到当天结束之后的新卡留到之后学习
+	var buried []riff.Card
+	for ret.NewCards < s.cfg.NewCardsPerDay && 0 < len(s.news) {
+		card := s.news[0]
+		s.news = s.news[1:]
+		if !card.GetBuriedUntil().Before(dayEnd) {
+			buried = append(buried, card)
+			continue
+		}
+		s.scheduler.Repeat(card, maxTime(dayStart, card.GetBuriedUntil()), s.sampleFirstRating())
+		heap.Push(&s.learned, card)
+		ret.NewCards++
+	}
+	s.news = append(buried, s.news...)
+
+	reviews := map[riff.Card]int{}
+	var deferred []riff.Card // 当天复习次数已达上限的闪卡
+	for 0 < s.learned.Len() && s.learned[0].GetDue().Before(dayEnd) {
+		if 0 < s.cfg.MaxReviewsPerDay && s.cfg.MaxReviewsPerDay <= ret.Reviews {
+			break
+		}
+
+		card := heap.Pop(&s.learned).(riff.Card)
+		if maxReviewsPerCardPerDay <= reviews[card] {
+			deferred = append(deferred, card)
+			continue
+		}
+
+		at := card.GetDue()
+		if at.Before(dayStart) {
+			at = dayStart
+		}
+		reviews[card]++
+		ret.Reviews++
+		if s.review(card, at) {
+			ret.Recalled++
+		}
+		heap.Push(&s.learned, card)
+	}
+	for _, card := range deferred {
+		heap.Push(&s.learned, card)
+	}
+
+	if 0 < ret.Reviews {
+		ret.Retention = float64(ret.Recalled) / float64(ret.Reviews)
+	}
+	for _, card := range s.learned {
+		ret.Knowledge += s.scheduler.Retrievability(card, dayEnd)
+	}
+	return
+}
+
+// review 在 at 时复习闪卡 card，按可提取性抽样是否回忆成功并返回。
+func (s *simulation) review(card riff.Card, at time.Time) (recalled bool) {
+	recalled = s.rand.Float64() < s.scheduler.Retrievability(card, at)
+	rating := riff.Again
+	if recalled {
+		rating = riff.Hard + riff.Rating(riff.SampleProbs(s.rand, s.recallRatingProbs))
+	}
+	s.scheduler.Repeat(card, at, rating)
+	return
+}
+
+func (s *simulation) sampleFirstRating() riff.Rating {
+	return riff.Again + riff.Rating(riff.SampleProbs(s.rand, s.firstRatingProbs))
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func isZeroProbs(probs []float64) bool {
+	for _, p := range probs {
+		if 0 != p {
+			return false
+		}
+	}
+	return true
+}
+
+// dueQueue 是按到期时间排序的闪卡最小堆，实现了 heap.Interface。
+type dueQueue []riff.Card
+
+func (q dueQueue) Len() int { return len(q) }
+
+func (q dueQueue) Less(i, j int) bool { return q[i].GetDue().Before(q[j].GetDue()) }
+
+func (q dueQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *dueQueue) Push(x any) { *q = append(*q, x.(riff.Card)) }
+
+func (q *dueQueue) Pop() any {
+	old := *q
+	n := len(old)
+	card := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return card
+}
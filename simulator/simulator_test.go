@@ -0,0 +1,115 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package simulator
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/siyuan-note/riff"
+)
+
+func TestSimulate(t *testing.T) {
+	const saveDir = "testdata"
+	os.MkdirAll(saveDir, 0755)
+	defer os.RemoveAll(saveDir)
+
+	deck, err := riff.LoadDeckWithOptions(saveDir, "simulator", nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		deck.AddCard("card"+strconv.Itoa(1000+i), "block"+strconv.Itoa(i))
+	}
+
+	start := time.Date(2023, 5, 20, 8, 0, 0, 0, time.Local)
+	simulate := func(requestRetention float64) *Result {
+		opts := riff.DefaultDeckOptions()
+		opts.FSRS.RequestRetention = requestRetention
+		result, err := Simulate(deck, &Config{Days: 60, NewCardsPerDay: 10, Options: opts, Start: start, Seed: 1})
+		if nil != err {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	result := simulate(0.9)
+	if 60 != len(result.Days) || 200 != result.TotalNewCards {
+		t.Fatalf("simulated [days=%d, new=%d]", len(result.Days), result.TotalNewCards)
+	}
+	if 0.7 > result.Retention || 1 < result.Retention {
+		t.Fatalf("simulated retention [%f]", result.Retention)
+	}
+	if 100 > result.Knowledge || 200 < result.Knowledge {
+		t.Fatalf("simulated knowledge [%f]", result.Knowledge)
+	}
+	if 0 != deck.GetCard("card1000").GetReps() {
+		t.Fatal("deck modified by simulation")
+	}
+
+	// 相同的种子得到相同的结果，更高的目标保留率需要更多的复习
+	if again := simulate(0.9); again.TotalReviews != result.TotalReviews {
+		t.Fatalf("simulation not deterministic [%d] != [%d]", again.TotalReviews, result.TotalReviews)
+	}
+	if higher := simulate(0.97); higher.TotalReviews <= result.TotalReviews {
+		t.Fatalf("reviews [%d] of higher retention <= [%d]", higher.TotalReviews, result.TotalReviews)
+	}
+
+	// 没有指定开始时间时使用闪卡包的时钟
+	deck.SetClock(riff.NewManualClock(start))
+	result, err = Simulate(deck, &Config{Days: 1, Seed: 1})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if !start.Equal(result.Days[0].Date) {
+		t.Fatalf("simulation start [%s] != deck clock [%s]", result.Days[0].Date, start)
+	}
+}
+
+func TestSimulateBuried(t *testing.T) {
+	const saveDir = "testdata"
+	os.MkdirAll(saveDir, 0755)
+	defer os.RemoveAll(saveDir)
+
+	start := time.Date(2023, 5, 20, 8, 0, 0, 0, time.Local)
+	deck, err := riff.LoadDeckWithClock(saveDir, "simulator-buried", nil, riff.NewManualClock(start))
+	if nil != err {
+		t.Fatal(err)
+	}
+	deck.AddCard("learned", "block0")
+	deck.ReviewAt("learned", riff.Good, start.AddDate(0, 0, -30))
+	deck.AddCard("new", "block1")
+
+	// 搁置三天的闪卡在第四天才会复习或者学习
+	for _, cardID := range []string{"learned", "new"} {
+		deck.Bury(cardID, start.AddDate(0, 0, 3))
+	}
+	result, err := Simulate(deck, &Config{Days: 5, NewCardsPerDay: 1, Seed: 1})
+	if nil != err {
+		t.Fatal(err)
+	}
+	for day := 0; day < 3; day++ {
+		if stats := result.Days[day]; 0 != stats.Reviews || 0 != stats.NewCards {
+			t.Fatalf("day [%d] simulated [reviews=%d, new=%d] while buried", day, stats.Reviews, stats.NewCards)
+		}
+	}
+	if stats := result.Days[3]; 1 > stats.Reviews || 1 != stats.NewCards {
+		t.Fatalf("day [3] simulated [reviews=%d, new=%d] after bury", stats.Reviews, stats.NewCards)
+	}
+}
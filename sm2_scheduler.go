@@ -0,0 +1,147 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	sm2InitialEaseFactor = 2.5 // 初始简易度
+	sm2MinEaseFactor     = 1.3 // 最小简易度
+	sm2IntervalRecall    = 0.9 // 到期时的预计回忆概率，用于估算可提取性
+)
+
+// SM2Scheduler 描述了 SM-2 算法调度器。
+type SM2Scheduler struct {
+	maximumInterval uint64
+}
+
+func NewSM2Scheduler(maximumInterval int) *SM2Scheduler {
+	if 1 > maximumInterval {
+		maximumInterval = 36500
+	}
+	return &SM2Scheduler{maximumInterval: uint64(maximumInterval)}
+}
+
+func (scheduler *SM2Scheduler) Algo() Algo {
+	return AlgoSM2
+}
+
+func (scheduler *SM2Scheduler) NewCard(id, blockID string) Card {
+	c := NewSM2Item()
+	return &SM2Card{BaseCard: &BaseCard{CID: id, BID: blockID}, C: &c}
+}
+
+func (scheduler *SM2Scheduler) Preview(card Card, now time.Time) (ret map[Rating]time.Time) {
+	item := *card.Impl().(*SM2Item)
+	ret = map[Rating]time.Time{}
+	for _, rating := range []Rating{Again, Hard, Good, Easy} {
+		ret[rating] = scheduler.repeat(item, now, rating).Due
+	}
+	return
+}
+
+func (scheduler *SM2Scheduler) Repeat(card Card, now time.Time, rating Rating) (ret *Log) {
+	last := *card.Impl().(*SM2Item)
+	updated := scheduler.repeat(last, now, rating)
+	card.SetImpl(&updated)
+
+	ret = &Log{
+		ID:            newIDAt(now),
+		CardID:        card.ID(),
+		Rating:        rating,
+		ScheduledDays: last.Interval,
+		ElapsedDays:   updated.ElapsedDays,
+		Reviewed:      now.Unix(),
+		State:         last.State,
+	}
+	return
+}
+
+// Retrievability 按指数遗忘曲线估算可提取性，SM-2 本身没有记忆模型，这里假设到期时的回忆概率为 0.9。
+func (scheduler *SM2Scheduler) Retrievability(card Card, now time.Time) float64 {
+	item := card.Impl().(*SM2Item)
+	if New == item.State || item.LastReview.IsZero() {
+		return 0
+	}
+
+	interval := math.Max(float64(item.Interval), 1)
+	elapsedDays := math.Max(now.Sub(item.LastReview).Hours()/24, 0)
+	return math.Pow(sm2IntervalRecall, elapsedDays/interval)
+}
+
+// repeat 使用 SM-2 算法计算 item 在 now 时以 rating 评分复习后的状态。
+func (scheduler *SM2Scheduler) repeat(item SM2Item, now time.Time, rating Rating) SM2Item {
+	if !item.LastReview.IsZero() {
+		item.ElapsedDays = uint64(math.Max(0, math.Floor(now.Sub(item.LastReview).Hours()/24)))
+	}
+
+	quality := sm2Quality(rating)
+	if 3 > quality {
+		if Review == item.State {
+			item.Lapses++
+			item.State = Relearning
+		} else if New == item.State {
+			item.State = Learning
+		}
+		item.Repetitions = 0
+		item.Interval = 1
+	} else {
+		switch item.Repetitions {
+		case 0:
+			item.Interval = 1
+		case 1:
+			item.Interval = 6
+		default:
+			item.Interval = uint64(math.Round(float64(item.Interval) * item.EaseFactor))
+		}
+		item.Repetitions++
+		item.State = Review
+
+		// 按 SM-2 算法只在回忆成功时调整简易度，回忆失败时只重新开始重复，简易度保持不变
+		q := float64(5 - quality)
+		item.EaseFactor += 0.1 - q*(0.08+q*0.02)
+		if sm2MinEaseFactor > item.EaseFactor {
+			item.EaseFactor = sm2MinEaseFactor
+		}
+	}
+
+	if scheduler.maximumInterval < item.Interval {
+		item.Interval = scheduler.maximumInterval
+	}
+	item.Reps++
+	item.LastReview = now
+	item.Due = now.Add(time.Duration(item.Interval) * 24 * time.Hour)
+	return item
+}
+
+// sm2Quality 将评分映射为 SM-2 算法中 0-5 的回答质量。
+func sm2Quality(rating Rating) int {
+	switch rating {
+	case Again:
+		return 1
+	case Hard:
+		return 3
+	case Good:
+		return 4
+	case Easy:
+		return 5
+	}
+	return 0
+}
@@ -0,0 +1,147 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"time"
+
+	"github.com/88250/gulu"
+	"github.com/siyuan-note/logging"
+)
+
+// SM2Store 描述了使用 SM-2 算法的闪卡存储。
+type SM2Store struct {
+	*BaseStore
+}
+
+func NewSM2Store(id, saveDir string, maximumInterval int) *SM2Store {
+	return &SM2Store{
+		BaseStore: NewBaseStore(id, saveDir, NewSM2Scheduler(maximumInterval)),
+	}
+}
+
+// NewSM2StoreWithOptions 使用闪卡包选项 opts 新建 SM-2 闪卡存储，选项无效时返回错误。
+func NewSM2StoreWithOptions(id, saveDir string, opts *DeckOptions) (ret *SM2Store, err error) {
+	if err = opts.SM2.Validate(); nil != err {
+		return
+	}
+	ret = &SM2Store{BaseStore: NewBaseStoreWithOptions(id, saveDir, NewSM2Scheduler(opts.SM2.MaximumInterval), opts)}
+	return
+}
+
+// SetOptions 校验并应用闪卡包选项 opts，并使用其中的 SM-2 算法参数重建调度算法。
+func (store *SM2Store) SetOptions(opts *DeckOptions) (err error) {
+	if err = opts.Validate(); nil != err {
+		return
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.applyOptions(opts, NewSM2Scheduler(opts.SM2.MaximumInterval))
+	return
+}
+
+// SM2Item 描述了 SM-2 算法中闪卡的复习状态。
+type SM2Item struct {
+	Due         time.Time // 到期时间
+	EaseFactor  float64   // 简易度
+	Interval    uint64    // 复习间隔天数
+	Repetitions uint64    // 连续答对次数
+	ElapsedDays uint64    // 距离上次复习的天数
+	Reps        uint64    // 复习次数
+	Lapses      uint64    // 遗忘次数
+	State       State     // 状态
+	LastReview  time.Time // 最后复习时间
+}
+
+func NewSM2Item() SM2Item {
+	return SM2Item{
+		EaseFactor: sm2InitialEaseFactor,
+		State:      New,
+	}
+}
+
+type SM2Card struct {
+	*BaseCard
+	C *SM2Item
+}
+
+func (card *SM2Card) Impl() interface{} {
+	return card.C
+}
+
+func (card *SM2Card) SetImpl(c interface{}) {
+	card.C = c.(*SM2Item)
+}
+
+func (card *SM2Card) GetLapses() int {
+	return int(card.C.Lapses)
+}
+
+func (card *SM2Card) GetReps() int {
+	return int(card.C.Reps)
+}
+
+func (card *SM2Card) GetState() State {
+	return card.C.State
+}
+
+// GetCardState 返回闪卡的复习状态，SM-2 假设到期时的回忆概率为 90%，所以将复习间隔作为记忆稳定性，没有难度。
+func (card *SM2Card) GetCardState() CardState {
+	ret := CardState{
+		Due:           card.C.Due,
+		ElapsedDays:   card.C.ElapsedDays,
+		ScheduledDays: card.C.Interval,
+		Reps:          int(card.C.Reps),
+		Lapses:        int(card.C.Lapses),
+		State:         card.C.State,
+		LastReview:    card.C.LastReview,
+	}
+	if New != card.C.State {
+		ret.Stability = float64(card.C.Interval)
+	}
+	return ret
+}
+
+func (card *SM2Card) GetLastReview() time.Time {
+	return card.C.LastReview
+}
+
+func (card *SM2Card) GetDue() time.Time {
+	return card.C.Due
+}
+
+func (card *SM2Card) Clone() Card {
+	data, err := gulu.JSON.MarshalJSON(card)
+	if nil != err {
+		logging.LogErrorf("marshal card failed: %s", err)
+		return nil
+	}
+	ret := &SM2Card{}
+	if err = gulu.JSON.UnmarshalJSON(data, ret); nil != err {
+		logging.LogErrorf("unmarshal card failed: %s", err)
+		return nil
+	}
+	return ret
+}
+
+func (card *SM2Card) SetDue(due time.Time) {
+	// 复制后修改，不影响已经取得的闪卡实现
+	c := *card.C
+	c.Due = due
+	card.C = &c
+}
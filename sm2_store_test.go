@@ -0,0 +1,91 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"math"
+	"os"
+	"testing"
+)
+
+func TestSM2Store(t *testing.T) {
+	const storePath = "testdata"
+	os.MkdirAll(storePath, 0755)
+	defer os.RemoveAll(storePath)
+
+	store := NewSM2Store("test-sm2-store", storePath, maximumInterval)
+	cardID, blockID := newID(), newID()
+	store.AddCard(cardID, blockID)
+	if 1 != len(store.GetNewCardsByBlockIDs([]string{blockID})) {
+		t.Fatalf("new cards by block ids [len=%d]", len(store.GetNewCardsByBlockIDs([]string{blockID})))
+	}
+
+	dues := store.Dues()
+	if 1 != len(dues) {
+		t.Fatalf("dues [len=%d]", len(dues))
+	}
+	if 4 != len(dues[0].NextDues()) {
+		t.Fatalf("next dues [len=%d]", len(dues[0].NextDues()))
+	}
+
+	for i, interval := range []uint64{1, 6, 15} {
+		log := store.Review(cardID, Good)
+		if nil == log {
+			t.Fatalf("review card [%s] failed", cardID)
+		}
+		state := store.GetCard(cardID).GetCardState()
+		if interval != state.ScheduledDays || float64(interval) != state.Stability {
+			t.Fatalf("review [%d] interval [%d] != [%d]", i, state.ScheduledDays, interval)
+		}
+	}
+
+	easeFactor := store.GetCard(cardID).Impl().(*SM2Item).EaseFactor
+	store.Review(cardID, Again)
+	card := store.GetCard(cardID)
+	c := card.Impl().(*SM2Item)
+	if 1 != c.Interval || 0 != c.Repetitions || 1 != card.GetLapses() || Relearning != card.GetState() {
+		t.Fatalf("lapsed card [interval=%d, repetitions=%d, lapses=%d, state=%d]", c.Interval, c.Repetitions, card.GetLapses(), card.GetState())
+	}
+	// 回忆失败时简易度保持不变
+	if sm2InitialEaseFactor != easeFactor || easeFactor != c.EaseFactor {
+		t.Fatalf("ease factor [%f] changed from [%f] after lapse", c.EaseFactor, easeFactor)
+	}
+
+	// 回忆成功时按回答质量调整简易度
+	store.Review(cardID, Hard)
+	if c = store.GetCard(cardID).Impl().(*SM2Item); math.Abs(easeFactor-0.14-c.EaseFactor) > 1e-9 {
+		t.Fatalf("ease factor [%f] != [%f] after hard", c.EaseFactor, easeFactor-0.14)
+	}
+
+	if err := store.Save(); nil != err {
+		t.Fatal(err)
+	}
+	if err := store.Load(); nil != err {
+		t.Fatal(err)
+	}
+
+	card = store.GetCard(cardID)
+	if nil == card {
+		t.Fatalf("card [%s] not found after load", cardID)
+	}
+	if 5 != card.GetReps() {
+		t.Fatalf("card reps [%d] != [5]", card.GetReps())
+	}
+	if 0 != len(store.GetNewCardsByBlockIDs([]string{blockID})) {
+		t.Fatalf("new cards by block ids [len=%d]", len(store.GetNewCardsByBlockIDs([]string{blockID})))
+	}
+}
@@ -0,0 +1,120 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"slices"
+	"time"
+)
+
+const defaultMatureInterval = 21 // 复习间隔达到该天数的闪卡为成熟闪卡
+
+var defaultRetentionWindows = []int{1, 7, 30, 365}
+
+// StatsOptions 描述了闪卡包统计的选项。
+type StatsOptions struct {
+	MatureInterval   int   // 复习间隔达到该天数的闪卡为成熟闪卡，默认为 21 天
+	RetentionWindows []int // 统计真实保留率的时间窗口天数，默认为 1、7、30 和 365 天
+}
+
+// DeckStats 描述了闪卡包的统计数据。
+type DeckStats struct {
+	Total             int               // 闪卡总数
+	States            map[State]int     // 各状态的闪卡数
+	Young             int               // 已复习但复习间隔未达到成熟天数的闪卡数
+	Mature            int               // 复习间隔达到成熟天数的闪卡数
+	Lapses            int               // 遗忘总次数
+	Reps              int               // 复习总次数
+	AverageStability  float64           // 已复习闪卡的平均记忆稳定性，算法没有对应概念时为 0
+	AverageDifficulty float64           // 已复习闪卡的平均难度，算法没有对应概念时为 0
+	Retentions        []*RetentionStats // 各个时间窗口内的真实保留率
+}
+
+// RetentionStats 描述了一个时间窗口内的真实保留率，即复习状态的闪卡在复习时回忆成功（评分不为 Again）的比例。
+type RetentionStats struct {
+	Days      int     // 时间窗口天数
+	Reviews   int     // 时间窗口内复习状态的闪卡的复习次数
+	Recalled  int     // 其中回忆成功的次数
+	Retention float64 // 真实保留率，没有复习时为 0
+}
+
+// Stats 返回闪卡包的统计数据。
+func (deck *Deck) Stats() (ret *DeckStats, err error) {
+	return deck.StatsWithOptions(nil)
+}
+
+// StatsWithOptions 使用统计选项 opts 返回闪卡包的统计数据，真实保留率从复习日志中统计。
+func (deck *Deck) StatsWithOptions(opts *StatsOptions) (ret *DeckStats, err error) {
+	matureInterval, windows := defaultMatureInterval, defaultRetentionWindows
+	if nil != opts {
+		if 0 < opts.MatureInterval {
+			matureInterval = opts.MatureInterval
+		}
+		if 0 < len(opts.RetentionWindows) {
+			windows = opts.RetentionWindows
+		}
+	}
+
+	deck.lock.Lock()
+	ret = &DeckStats{States: map[State]int{New: 0, Learning: 0, Review: 0, Relearning: 0}}
+	reviewed := 0
+	for _, card := range deck.store.GetCardsByBlockIDs(deck.store.GetBlockIDs()) {
+		state := card.GetCardState()
+		ret.Total++
+		ret.States[state.State]++
+		ret.Lapses += state.Lapses
+		ret.Reps += state.Reps
+		if New == state.State {
+			continue
+		}
+
+		if uint64(matureInterval) <= state.ScheduledDays {
+			ret.Mature++
+		} else {
+			ret.Young++
+		}
+		reviewed++
+		ret.AverageStability += (state.Stability - ret.AverageStability) / float64(reviewed)
+		ret.AverageDifficulty += (state.Difficulty - ret.AverageDifficulty) / float64(reviewed)
+	}
+	now := deck.clock.Now()
+	deck.lock.Unlock()
+
+	for _, days := range windows {
+		ret.Retentions = append(ret.Retentions, &RetentionStats{Days: days})
+	}
+	query := &LogQuery{States: []State{Review}, Start: now.AddDate(0, 0, -slices.Max(windows)), End: now.Add(time.Second)}
+	err = deck.Logs(query, func(log *Log) bool {
+		reviewed := time.Unix(log.Reviewed, 0)
+		for _, retention := range ret.Retentions {
+			if reviewed.Before(now.AddDate(0, 0, -retention.Days)) {
+				continue
+			}
+			retention.Reviews++
+			if Again != log.Rating {
+				retention.Recalled++
+			}
+		}
+		return true
+	})
+	for _, retention := range ret.Retentions {
+		if 0 < retention.Reviews {
+			retention.Retention = float64(retention.Recalled) / float64(retention.Reviews)
+		}
+	}
+	return
+}
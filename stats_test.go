@@ -0,0 +1,78 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	const saveDir = "testdata"
+	os.MkdirAll(saveDir, 0755)
+	defer os.RemoveAll(saveDir)
+
+	clock := NewManualClock(time.Date(2023, 5, 20, 8, 0, 0, 0, time.Local))
+	deck, err := LoadDeckWithClock(saveDir, newID(), nil, clock)
+	if nil != err {
+		t.Fatal(err)
+	}
+	newCardID, learningID, matureID := newID(), newID(), newID()
+	deck.AddCard(newCardID, newID())
+	deck.AddCard(learningID, newID())
+	deck.AddCard(matureID, newID())
+	deck.Review(learningID, Good)
+	deck.Review(matureID, Easy)
+	for i := 0; i < 10 && 21 > deck.GetCard(matureID).GetCardState().ScheduledDays; i++ {
+		clock.Set(deck.GetCard(matureID).GetDue())
+		deck.Review(matureID, Easy)
+	}
+
+	now := clock.Now()
+	for _, log := range []*Log{
+		{ID: newID(), CardID: matureID, Rating: Good, State: Review, Reviewed: now.AddDate(0, 0, -2).Unix()},
+		{ID: newID(), CardID: matureID, Rating: Again, State: Review, Reviewed: now.AddDate(0, 0, -10).Unix()},
+		{ID: newID(), CardID: matureID, Rating: Good, State: Review, Reviewed: now.AddDate(0, 0, -100).Unix()},
+		{ID: newID(), CardID: learningID, Rating: Again, State: Learning, Reviewed: now.Add(-time.Hour).Unix()},
+	} {
+		if err = deck.SaveLog(log); nil != err {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := deck.Stats()
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 3 != stats.Total || 1 != stats.States[New] || 1 != stats.States[Learning] || 1 != stats.States[Review] {
+		t.Fatalf("states %v mismatched", stats.States)
+	}
+	if 1 != stats.Young || 1 != stats.Mature || 0 != stats.Lapses || 1 >= stats.Reps {
+		t.Fatalf("stats [young=%d, mature=%d, lapses=%d, reps=%d] mismatched", stats.Young, stats.Mature, stats.Lapses, stats.Reps)
+	}
+	if 0 >= stats.AverageStability || 0 >= stats.AverageDifficulty {
+		t.Fatalf("average [stability=%f, difficulty=%f]", stats.AverageStability, stats.AverageDifficulty)
+	}
+
+	expected := [][2]int{{0, 0}, {1, 1}, {2, 1}, {3, 2}}
+	for i, retention := range stats.Retentions {
+		if expected[i][0] != retention.Reviews || expected[i][1] != retention.Recalled {
+			t.Fatalf("retention of [%d] days [reviews=%d, recalled=%d] mismatched", retention.Days, retention.Reviews, retention.Recalled)
+		}
+	}
+}
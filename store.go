@@ -19,8 +19,12 @@ package riff
 import (
 	"math/rand"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
+
+	"github.com/siyuan-note/logging"
+	"github.com/vmihailenco/msgpack/v5"
 )
 
 // Store 描述了闪卡存储。
@@ -35,6 +39,9 @@ type Store interface {
 	// SetCard 设置一张卡片。
 	SetCard(card Card)
 
+	// SetDue 设置 id 卡片的到期时间并更新到期索引，卡片不存在时返回 nil。
+	SetDue(id string, due time.Time) Card
+
 	// RemoveCard 移除一张卡片。
 	RemoveCard(id string) Card
 
@@ -44,57 +51,141 @@ type Store interface {
 	// GetCardsByBlockIDs 获取指定内容块的所有卡片。
 	GetCardsByBlockIDs(blockIDs []string) []Card
 
-	// GetNewCardsByBlockIDs 获取指定内容块的所有新的卡片（制卡后没有进行过复习的卡片）。
+	// GetNewCardsByBlockIDs 获取指定内容块的所有新的卡片（制卡后没有进行过复习的卡片），不包含暂停和搁置的卡片。
 	GetNewCardsByBlockIDs(blockIDs []string) []Card
 
-	// GetDueCardsByBlockIDs 获取指定内容块的所有到期的卡片。
+	// GetNewCardsByBlockIDsWithOptions 使用查询选项 opts 获取指定内容块的所有新的卡片。
+	GetNewCardsByBlockIDsWithOptions(blockIDs []string, opts *QueryOptions) []Card
+
+	// GetDueCardsByBlockIDs 获取指定内容块的所有到期的卡片，不包含暂停和搁置的卡片。
 	GetDueCardsByBlockIDs(blockIDs []string) []Card
 
+	// GetDueCardsByBlockIDsWithOptions 使用查询选项 opts 获取指定内容块的所有到期的卡片。
+	GetDueCardsByBlockIDsWithOptions(blockIDs []string, opts *QueryOptions) []Card
+
+	// GetCardsByTag 获取带有标签 tag 的所有卡片。
+	GetCardsByTag(tag string) []Card
+
 	// GetBlockIDs 获取所有内容块 ID。
 	GetBlockIDs() []string
 
 	// CountCards 获取卡包中的闪卡数量。
 	CountCards() int
 
-	// Review 闪卡复习。
+	// Review 闪卡复习，评分 rating 不在 Again 和 Easy 之间时不复习并返回 nil。
 	Review(id string, rating Rating) (ret *Log)
 
-	// Dues 获取所有到期的闪卡列表。
+	// ReviewAt 在 at 时复习闪卡，比如导入离线复习的记录，at 早于闪卡的最后复习时间时不复习并返回 nil。
+	ReviewAt(id string, rating Rating, at time.Time) (ret *Log)
+
+	// Dues 获取所有到期的闪卡列表，不包含暂停和搁置的闪卡，按闪卡包的排序策略排序。
 	Dues() []Card
 
+	// DuesWithOptions 使用查询选项 opts 获取所有到期的闪卡列表，比如指定排序策略。
+	DuesWithOptions(opts *QueryOptions) []Card
+
+	// Retrievability 返回闪卡在 at 时的可提取性（回忆概率），闪卡不存在或者是新卡时返回 0。
+	Retrievability(id string, at time.Time) float64
+
+	// WithScheduler 持有存储的锁，使用存储的调度算法 scheduler 和所有闪卡 cards 运行 fn 并返回 fn 的错误，比如统计可提取性和预测复习量。
+	//
+	// cards 按 ID 排序，fn 不能调用存储的其他方法，修改闪卡时需要先克隆。
+	WithScheduler(fn func(scheduler Scheduler, cards []Card) error) error
+
 	// ID 获取存储 ID。
 	ID() string
 
 	// Algo 返回算法名称，如：fsrs。
 	Algo() Algo
 
-	// Load 从持久化存储中加载全部闪卡到内存。
+	// Load 从持久化存储中加载全部闪卡到内存，并重放上次保存之后的变更。
 	Load() (err error)
 
-	// Save 将全部闪卡从内存保存到持久化存储中。
+	// Save 将全部闪卡从内存保存到持久化存储中，并清空已保存的变更。
 	Save() error
 
 	// SaveLog 保存复习日志。
 	SaveLog(log *Log) error
 
+	// RemoveLog 删除复习日志，比如撤销复习。
+	RemoveLog(log *Log) error
+
 	// GetSaveDir 获取数据文件夹路径。
 	GetSaveDir() string
+
+	// SetOptions 应用闪卡包选项 opts，比如难记闪卡处理选项、备份份数和算法参数，opts 无效时返回错误。
+	SetOptions(opts *DeckOptions) error
+
+	// SetClock 设置存储获取当前时间使用的时钟。
+	SetClock(clock Clock)
 }
 
-// BaseStore 描述了基础的闪卡存储实现。
+// BaseStore 描述了基础的闪卡存储实现，闪卡在内存中以 ID 索引，持久化为 msgpack 文件，复习调度交给 Scheduler。
 type BaseStore struct {
-	id      string      // 存储 ID，应该和卡包 ID 一致
-	algo    Algo        // 算法名称，如：fsrs
-	saveDir string      // 数据文件夹路径，如：F:\\SiYuan\\data\\storage\\riff\\
-	lock    *sync.Mutex // 操作时需要用到的锁
+	id        string                     // 存储 ID，应该和卡包 ID 一致
+	algo      Algo                       // 算法名称，如：fsrs
+	saveDir   string                     // 数据文件夹路径，如：F:\\SiYuan\\data\\storage\\riff\\
+	lock      *sync.Mutex                // 操作时需要用到的锁
+	cards     map[string]Card            // 闪卡
+	blocks    map[string]map[string]Card // 内容块 ID 到闪卡的索引
+	tags      map[string]map[string]Card // 标签到闪卡的索引
+	cardTags  map[string][]string        // 闪卡 ID 到建立索引时的标签
+	dues      dueHeap                    // 按到期时间排序的闪卡
+	dueItems  map[string]*dueItem        // 闪卡 ID 到到期索引项的映射
+	scheduler Scheduler                  // 调度算法
+	backups   int                        // 保存时轮转保留的备份份数
+	clock     Clock                      // 时钟
+	leech     LeechOptions               // 难记闪卡处理选项
+	order     DueOrder                   // 到期闪卡的排序策略
+	seed      int64                      // 打乱顺序使用的随机数种子
 }
 
-func NewBaseStore(id string, algo Algo, saveDir string) *BaseStore {
+func NewBaseStore(id, saveDir string, scheduler Scheduler) *BaseStore {
 	return &BaseStore{
-		id:      id,
-		algo:    algo,
-		saveDir: saveDir,
-		lock:    &sync.Mutex{},
+		id:        id,
+		algo:      scheduler.Algo(),
+		saveDir:   saveDir,
+		lock:      &sync.Mutex{},
+		cards:     map[string]Card{},
+		blocks:    map[string]map[string]Card{},
+		tags:      map[string]map[string]Card{},
+		cardTags:  map[string][]string{},
+		dues:      dueHeap{},
+		dueItems:  map[string]*dueItem{},
+		scheduler: scheduler,
+		backups:   defaultBackups,
+		clock:     SystemClock,
+		leech:     LeechOptions{Threshold: defaultLeechThreshold},
+	}
+}
+
+// NewBaseStoreWithOptions 新建闪卡存储，并应用闪卡包选项 opts 中与存储相关的选项。
+func NewBaseStoreWithOptions(id, saveDir string, scheduler Scheduler, opts *DeckOptions) *BaseStore {
+	ret := NewBaseStore(id, saveDir, scheduler)
+	ret.applyOptions(opts, nil)
+	return ret
+}
+
+// SetOptions 校验并应用闪卡包选项 opts 中与存储相关的选项，调度算法保持不变。
+func (store *BaseStore) SetOptions(opts *DeckOptions) (err error) {
+	if err = opts.Validate(); nil != err {
+		return
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.applyOptions(opts, nil)
+	return
+}
+
+// applyOptions 应用闪卡包选项 opts 中与存储相关的选项，scheduler 不为 nil 时替换调度算法，调用方需要持有 store.lock。
+func (store *BaseStore) applyOptions(opts *DeckOptions, scheduler Scheduler) {
+	store.backups = opts.backups()
+	store.leech = opts.Leech
+	store.order = opts.Order
+	store.seed = opts.ShuffleSeed
+	if nil != scheduler {
+		store.scheduler = scheduler
 	}
 }
 
@@ -110,8 +201,300 @@ func (store *BaseStore) GetSaveDir() string {
 	return store.saveDir
 }
 
+func (store *BaseStore) SetClock(clock Clock) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if nil == clock {
+		clock = SystemClock
+	}
+	store.clock = clock
+}
+
+func (store *BaseStore) AddCard(id, blockID string) Card {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	card := store.scheduler.NewCard(id, blockID)
+	store.putCard(card)
+	store.appendJournal(journalAdd, id, card)
+	return card
+}
+
+func (store *BaseStore) GetCard(id string) Card {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	return store.cards[id]
+}
+
+func (store *BaseStore) SetCard(card Card) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.putCard(card)
+	store.appendJournal(journalSet, card.ID(), card)
+}
+
+func (store *BaseStore) SetDue(id string, due time.Time) Card {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	card := store.cards[id]
+	if nil == card {
+		logging.LogWarnf("not found card [id=%s] to set due", id)
+		return nil
+	}
+	card.SetDue(due)
+	store.fixDue(card)
+	store.appendJournal(journalSet, id, card)
+	return card
+}
+
+func (store *BaseStore) RemoveCard(id string) Card {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	card := store.deleteCard(id)
+	if nil == card {
+		return nil
+	}
+	store.appendJournal(journalRemove, id, nil)
+	return card
+}
+
+func (store *BaseStore) GetCardsByBlockID(blockID string) (ret []Card) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	return store.getCardsByBlockIDs([]string{blockID}, nil)
+}
+
+func (store *BaseStore) GetCardsByBlockIDs(blockIDs []string) (ret []Card) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	return store.getCardsByBlockIDs(blockIDs, nil)
+}
+
+func (store *BaseStore) GetNewCardsByBlockIDs(blockIDs []string) (ret []Card) {
+	return store.GetNewCardsByBlockIDsWithOptions(blockIDs, nil)
+}
+
+func (store *BaseStore) GetNewCardsByBlockIDsWithOptions(blockIDs []string, opts *QueryOptions) (ret []Card) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	now := store.clock.Now()
+	return store.getCardsByBlockIDs(blockIDs, func(card Card) bool {
+		return card.GetLastReview().IsZero() && opts.match(card, now)
+	})
+}
+
+func (store *BaseStore) GetDueCardsByBlockIDs(blockIDs []string) (ret []Card) {
+	return store.GetDueCardsByBlockIDsWithOptions(blockIDs, nil)
+}
+
+func (store *BaseStore) GetDueCardsByBlockIDsWithOptions(blockIDs []string, opts *QueryOptions) (ret []Card) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	now := store.clock.Now()
+	ret = store.getCardsByBlockIDs(blockIDs, func(card Card) bool {
+		return !now.Before(card.GetDue()) && opts.match(card, now)
+	})
+	store.sortDues(ret, opts, now)
+	return
+}
+
+func (store *BaseStore) GetCardsByTag(tag string) (ret []Card) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	for _, card := range store.tags[tag] {
+		ret = append(ret, card)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ID() < ret[j].ID() })
+	return
+}
+
+func (store *BaseStore) GetBlockIDs() (ret []string) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	ret = []string{}
+	for blockID := range store.blocks {
+		ret = append(ret, blockID)
+	}
+	sort.Strings(ret)
+	return
+}
+
+func (store *BaseStore) CountCards() int {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	return len(store.cards)
+}
+
+func (store *BaseStore) WithScheduler(fn func(scheduler Scheduler, cards []Card) error) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	cards := make([]Card, 0, len(store.cards))
+	for _, card := range store.cards {
+		cards = append(cards, card)
+	}
+	sort.Slice(cards, func(i, j int) bool { return cards[i].ID() < cards[j].ID() })
+	return fn(store.scheduler, cards)
+}
+
+func (store *BaseStore) Review(cardId string, rating Rating) (ret *Log) {
+	return store.ReviewAt(cardId, rating, store.getClock().Now())
+}
+
+func (store *BaseStore) ReviewAt(cardId string, rating Rating, now time.Time) (ret *Log) {
+	if Again > rating || Easy < rating {
+		logging.LogWarnf("invalid rating [%d] to review card [id=%s]", rating, cardId)
+		return
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	card := store.cards[cardId]
+	if nil == card {
+		logging.LogWarnf("not found card [id=%s] to review", cardId)
+		return
+	}
+	if lastReview := card.GetLastReview(); now.Before(lastReview) {
+		// 早于最后复习时间的复习会使间隔天数为负数，调度算法无法处理
+		logging.LogWarnf("review card [id=%s] at [%s] before its last review [%s]", cardId, now, lastReview)
+		return
+	}
+
+	ret = store.scheduler.Repeat(card, now, rating)
+	ret.DeckID = store.id
+	store.markLeech(card, ret)
+	store.fixDue(card)
+	store.appendJournal(journalReview, cardId, card)
+	return
+}
+
+func (store *BaseStore) Dues() (ret []Card) {
+	return store.DuesWithOptions(nil)
+}
+
+func (store *BaseStore) DuesWithOptions(opts *QueryOptions) (ret []Card) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	now := store.clock.Now()
+	for _, card := range store.getDueCards(now) {
+		if !opts.match(card, now) {
+			continue
+		}
+		// 只为返回的闪卡计算下次到期时间
+		card.SetNextDues(store.scheduler.Preview(card, now))
+		ret = append(ret, card)
+	}
+	store.sortDues(ret, opts, now)
+	return
+}
+
+func (store *BaseStore) Load() (err error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.resetCards()
+	p := store.getMsgPackPath()
+	_, generation, err := readFileGeneration(p, func(data []byte) (err error) {
+		raws := map[string]msgpack.RawMessage{}
+		if err = msgpack.Unmarshal(data, &raws); nil != err {
+			return
+		}
+
+		cards := map[string]Card{}
+		for id, raw := range raws {
+			card := store.scheduler.NewCard(id, "")
+			if err = msgpack.Unmarshal(raw, card); nil != err {
+				return
+			}
+			cards[id] = card
+		}
+		store.resetCards()
+		for _, card := range cards {
+			store.putCard(card)
+		}
+		return
+	})
+	if nil != err {
+		logging.LogErrorf("load cards failed: %s", err)
+		return
+	}
+	if err = store.replayJournal(generation); nil != err {
+		return
+	}
+
+	if splitErr := splitSharedLogs(store.saveDir); nil != splitErr {
+		logging.LogErrorf("split shared logs failed: %s", splitErr)
+	}
+	return
+}
+
+func (store *BaseStore) Save() (err error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	p := store.getMsgPackPath()
+	data, err := msgpack.Marshal(store.cards)
+	if nil != err {
+		logging.LogErrorf("save cards failed: %s", err)
+		return
+	}
+	if err = writeFileAtomic(p, data, store.backups); nil != err {
+		logging.LogErrorf("save cards failed: %s", err)
+		return
+	}
+	err = store.truncateJournal()
+	return
+}
+
+func (store *BaseStore) SaveLog(log *Log) (err error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	log.DeckID = store.id
+	if err = appendLog(getDeckLogsDir(store.saveDir, store.id), log); nil != err {
+		logging.LogErrorf("save log failed: %s", err)
+		return
+	}
+	return
+}
+
+func (store *BaseStore) RemoveLog(log *Log) (err error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if err = removeLog(getDeckLogsDir(store.saveDir, store.id), log); nil != err {
+		logging.LogErrorf("remove log failed: %s", err)
+		return
+	}
+	return
+}
+
+func (store *BaseStore) getClock() Clock {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	return store.clock
+}
+
 func (store *BaseStore) getMsgPackPath() string {
-	return filepath.Join(store.saveDir, store.id+".cards")
+	return getCardsMsgpackPath(store.saveDir, store.id)
+}
+
+func getCardsMsgpackPath(saveDir, id string) string {
+	return filepath.Join(saveDir, id+".cards")
 }
 
 // Rating 描述了闪卡复习的评分。
@@ -143,7 +526,11 @@ const (
 )
 
 func newID() string {
-	now := time.Now()
+	return newIDAt(SystemClock.Now())
+}
+
+// newIDAt 返回 now 时生成的 ID。
+func newIDAt(now time.Time) string {
 	return now.Format("20060102150405") + "-" + randStr(7)
 }
 
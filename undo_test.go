@@ -0,0 +1,149 @@
+// Riff - Spaced repetition.
+// Copyright (c) 2022-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package riff
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestUndoReview(t *testing.T) {
+	const saveDir = "testdata"
+	os.MkdirAll(saveDir, 0755)
+	defer os.RemoveAll(saveDir)
+
+	deck, err := LoadDeckWithOptions(saveDir, newID(), nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	cardID := newID()
+	deck.AddCard(cardID, newID())
+
+	var logs []*Log
+	for _, rating := range []Rating{Good, Again} {
+		log := deck.Review(cardID, rating)
+		if err = deck.SaveLog(log); nil != err {
+			t.Fatal(err)
+		}
+		logs = append(logs, log)
+	}
+	reviewed := deck.GetCard(cardID).GetCardState()
+
+	// 撤销误点的 Again
+	undone, err := deck.UndoReview()
+	if nil != err {
+		t.Fatal(err)
+	}
+	if logs[1].ID != undone.ID {
+		t.Fatalf("undone log [%s] != [%s]", undone.ID, logs[1].ID)
+	}
+	state := deck.GetCard(cardID).GetCardState()
+	if 1 != state.Reps || 0 != state.Lapses || state.Due.Equal(reviewed.Due) {
+		t.Fatalf("card state [%+v] not restored", state)
+	}
+
+	var ids []string
+	if err = deck.Logs(nil, func(log *Log) bool {
+		ids = append(ids, log.ID)
+		return true
+	}); nil != err {
+		t.Fatal(err)
+	}
+	if 1 != len(ids) || logs[0].ID != ids[0] {
+		t.Fatalf("logs %v after undo mismatched", ids)
+	}
+
+	if undone, _ = deck.UndoReview(); nil == undone {
+		t.Fatal("first review not undone")
+	}
+	if 0 != deck.GetCard(cardID).GetReps() || 1 != len(deck.Dues()) {
+		t.Fatal("card not restored to new")
+	}
+	if undone, _ = deck.UndoReview(); nil != undone {
+		t.Fatal("nothing should be undone")
+	}
+}
+
+func TestUndoReviewKeepsLaterChanges(t *testing.T) {
+	const saveDir = "testdata"
+	os.MkdirAll(saveDir, 0755)
+	defer os.RemoveAll(saveDir)
+
+	deck, err := LoadDeckWithOptions(saveDir, newID(), nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	cardID := newID()
+	deck.AddCard(cardID, newID())
+	deck.Review(cardID, Good)
+
+	// 复习之后添加的标签和搁置不会被撤销
+	until := deck.Now().Add(24 * time.Hour)
+	deck.AddTags(cardID, "x")
+	deck.Bury(cardID, until)
+	if undone, _ := deck.UndoReview(); nil == undone {
+		t.Fatal("review not undone")
+	}
+	card := deck.GetCard(cardID)
+	if 0 != card.GetReps() || !card.HasTag("x") || !card.GetBuriedUntil().Equal(until) {
+		t.Fatalf("card [reps=%d, tags=%v, buried=%s] mismatched after undo", card.GetReps(), card.GetTags(), card.GetBuriedUntil())
+	}
+	if cards := deck.CardsByTag("x"); 1 != len(cards) {
+		t.Fatalf("tag cards len [%d] != [1]", len(cards))
+	}
+}
+
+func TestUndoReviewAfterCardChanged(t *testing.T) {
+	const saveDir = "testdata"
+	os.MkdirAll(saveDir, 0755)
+	defer os.RemoveAll(saveDir)
+
+	deck, err := LoadDeckWithOptions(saveDir, newID(), nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	changedID, removedID := newID(), newID()
+	deck.AddCard(changedID, newID())
+	deck.AddCard(removedID, newID())
+	deck.Review(changedID, Good)
+
+	// 复习之后重新设置了到期时间，不能再撤销，闪卡保持修改后的状态
+	due := deck.Now().AddDate(0, 0, 7)
+	deck.SetDue(changedID, due)
+	if undone, _ := deck.UndoReview(); nil != undone {
+		t.Fatalf("review [%s] of changed card undone", undone.ID)
+	}
+	if card := deck.GetCard(changedID); 1 != card.GetReps() || !card.GetDue().Equal(due) {
+		t.Fatalf("changed card [reps=%d, due=%s] restored", card.GetReps(), card.GetDue())
+	}
+
+	// 闪卡被移除后重新添加，之前的复习不能再撤销
+	deck.Review(removedID, Good)
+	deck.RemoveCard(removedID)
+	deck.AddCard(removedID, newID())
+	if undone, _ := deck.UndoReview(); nil != undone {
+		t.Fatalf("review [%s] of removed card undone", undone.ID)
+	}
+
+	// 绕过闪卡包直接修改调度状态时，撤销返回错误
+	deck.Review(changedID, Good)
+	deck.store.SetDue(changedID, due)
+	if undone, err := deck.UndoReview(); nil != undone || ErrCardChangedSinceReview != err {
+		t.Fatalf("undo changed card [undone=%v, err=%v]", undone, err)
+	}
+}